@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// redactedInstruction replaces the instruction in the logs when
+// LOG_REDACT_INSTRUCTIONS is set.
+const redactedInstruction = "[redacted]"
+
+// redactInstructions keeps instructions out of the logs and traces, set up
+// in main from LogRedactInstructions.
+var redactInstructions bool
+
+// quotedTextError is an error quoting the instruction, or model output,
+// which often repeats it.
+type quotedTextError struct {
+	msg  string
+	text string
+}
+
+func (e *quotedTextError) Error() string {
+	return fmt.Sprintf("%s %q", e.msg, e.text)
+}
+
+// accessEntry collects what the access log line of an /api request
+// reports.
+type accessEntry struct {
+	start       time.Time
+	instruction string
+	command     AIResponse
+	aiLatency   time.Duration
+	rec         *commandRecorder
+}
+
+// logInstruction returns instruction as it may appear in the logs.
+func logInstruction(instruction string) string {
+	if redactInstructions && instruction != "" {
+		return redactedInstruction
+	}
+	return instruction
+}
+
+// logError returns err as it may appear in the logs: when instructions are
+// redacted, the text quoted by a *quotedTextError in its chain is replaced,
+// keeping the rest of the message.
+func logError(err error) error {
+	var quoted *quotedTextError
+	if !redactInstructions || !errors.As(err, &quoted) {
+		return err
+	}
+	return errors.New(strings.Replace(err.Error(), quoted.Error(), quoted.msg+" "+redactedInstruction, 1))
+}
+
+// logAccess writes the access log line of an /api request: one JSON entry
+// with the command, the device paths written and the outcome, meant for
+// log aggregation and dashboards. The time and request ID come from the
+// request logger.
+func logAccess(c *gin.Context, cfg Config, e *accessEntry) {
+	paths := []string{}
+	if e.rec != nil {
+		seen := make(map[string]bool)
+		e.rec.mu.Lock()
+		for _, w := range e.rec.writes {
+			if !seen[w.Path] {
+				seen[w.Path] = true
+				paths = append(paths, w.Path)
+			}
+		}
+		e.rec.mu.Unlock()
+	}
+	logFromContext(c.Request.Context()).Info("access",
+		"instruction", logInstruction(e.instruction),
+		"target", e.command.Target,
+		"action", e.command.Action,
+		"location", strings.Join(e.command.targetLocations(), ", "),
+		"paths", paths,
+		"status", c.Writer.Status(),
+		"latency_ms", time.Since(e.start).Milliseconds(),
+		"ai_latency_ms", e.aiLatency.Milliseconds(),
+	)
+}
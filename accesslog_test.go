@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func useRedaction(t *testing.T, on bool) {
+	t.Helper()
+	prev := redactInstructions
+	redactInstructions = on
+	t.Cleanup(func() { redactInstructions = prev })
+}
+
+func TestLogError(t *testing.T) {
+	const secret = "open the safe in the study"
+	err := errors.Wrap(&quotedTextError{msg: "no mock AI response matches", text: secret}, "classify")
+
+	useRedaction(t, false)
+	if got := logError(err).Error(); !strings.Contains(got, secret) {
+		t.Errorf("logError() = %q without redaction, want the instruction", got)
+	}
+
+	useRedaction(t, true)
+	got := logError(err).Error()
+	if strings.Contains(got, secret) {
+		t.Errorf("logError() = %q, want the instruction redacted", got)
+	}
+	if want := "classify: no mock AI response matches " + redactedInstruction; got != want {
+		t.Errorf("logError() = %q, want %q", got, want)
+	}
+	if other := errors.New("connection refused"); logError(other) != other {
+		t.Error("logError() changed an error without quoted text")
+	}
+	if logError(nil) != nil {
+		t.Error("logError(nil) is not nil")
+	}
+}
+
+func TestRedactedMockError(t *testing.T) {
+	useRedaction(t, true)
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	useProvider(t, mockProvider{})
+
+	// The keyword fallback answers, logging why the mock did not.
+	if _, err := getAIResponse(context.Background(), "turn on the bedroom light for the plumber", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "no mock AI response matches "+redactedInstruction) {
+		t.Errorf("logs do not report the mock error: %s", logs.String())
+	}
+	if strings.Contains(logs.String(), "plumber") {
+		t.Errorf("logs contain the instruction: %s", logs.String())
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// defaultActionSynonyms maps the words a model or client may use for an
+// action to the action the targets understand. ACTION_SYNONYMS_FILE adds to
+// or overrides these entries.
+var defaultActionSynonyms = map[string]string{
+	"enable":     "on",
+	"activate":   "on",
+	"start":      "on",
+	"turn on":    "on",
+	"switch on":  "on",
+	"power on":   "on",
+	"disable":    "off",
+	"deactivate": "off",
+	"turn off":   "off",
+	"switch off": "off",
+	"power off":  "off",
+	"shut":       "close",
+	"shut down":  "off",
+	"unlock":     "open",
+	"lock":       "close",
+	"raise":      "open",
+	"lower":      "close",
+	"resume":     "play",
+	"brighten":   "increase",
+	"turn up":    "increase",
+	"turn down":  "decrease",
+	"silence":    "mute",
+	"colour":     "color",
+	"flip":       "toggle",
+	"switch":     "toggle",
+}
+
+// actionSynonyms holds the active synonyms, the defaults merged with the
+// synonyms file.
+var actionSynonyms atomic.Pointer[map[string]string]
+
+func init() {
+	synonyms := maps.Clone(defaultActionSynonyms)
+	actionSynonyms.Store(&synonyms)
+}
+
+// loadActionSynonyms returns the default synonyms merged with the ones in
+// file, a JSON object such as {"engage": "on"}.
+func loadActionSynonyms(file string) (map[string]string, error) {
+	merged := maps.Clone(defaultActionSynonyms)
+	if file == "" {
+		return merged, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read action synonyms file")
+	}
+	var synonyms map[string]string
+	if err := json.Unmarshal(data, &synonyms); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse action synonyms file %s", file)
+	}
+	for synonym, action := range synonyms {
+		synonym, action = normalizeAction(synonym), normalizeAction(action)
+		if synonym == "" || action == "" {
+			return nil, errors.Errorf("invalid action synonyms file %s: empty synonym or action", file)
+		}
+		merged[synonym] = action
+	}
+	return merged, nil
+}
+
+func normalizeAction(action string) string {
+	return strings.Join(strings.Fields(strings.ToLower(action)), " ")
+}
+
+// canonicalAction returns the action target understands for action. An
+// action the target supports as it is wins over a synonym, so that a
+// target may give a synonym of another action its own meaning.
+func canonicalAction(target, action string) string {
+	action = normalizeAction(action)
+	if slices.Contains(targetActions[target], action) {
+		return action
+	}
+	if canonical, ok := (*actionSynonyms.Load())[action]; ok {
+		return canonical
+	}
+	return action
+}
+
+// withCanonicalAction returns r with its action canonicalized.
+func (r AIResponse) withCanonicalAction() AIResponse {
+	r.Action = canonicalAction(r.Target, r.Action)
+	return r
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCanonicalActionSynonyms(t *testing.T) {
+	for synonym, want := range defaultActionSynonyms {
+		// A target without actions of its own always takes the synonym.
+		if got := canonicalAction("", synonym); got != want {
+			t.Errorf("canonicalAction(%q) = %q, want %q", synonym, got, want)
+		}
+	}
+}
+
+func TestCanonicalAction(t *testing.T) {
+	tests := []struct {
+		target string
+		action string
+		want   string
+	}{
+		{"light", "Turn  ON", "on"},
+		{"light", "ENABLE", "on"},
+		{"fan", "deactivate", "off"},
+		{"light", "on", "on"},
+		{"door", "lock", "lock"},
+		{"door", "unlock", "unlock"},
+		{"door", "shut", "close"},
+		{"light", "explode", "explode"},
+	}
+	for _, tt := range tests {
+		if got := canonicalAction(tt.target, tt.action); got != tt.want {
+			t.Errorf("canonicalAction(%q, %q) = %q, want %q", tt.target, tt.action, got, tt.want)
+		}
+	}
+}
+
+func TestUnknownActionAfterSynonyms(t *testing.T) {
+	useFakeBackend(t)
+	status, body := runCommand(context.Background(), AIResponse{Target: "light", Action: "explode", Location: "kitchen"})
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422: %v", status, body)
+	}
+	if _, ok := body["supported_actions"]; !ok {
+		t.Errorf("body %v does not list the supported actions", body)
+	}
+}
+
+func TestLoadActionSynonyms(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "synonyms.json")
+	if err := os.WriteFile(file, []byte(`{"Engage": "ON", "shut": "off"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	synonyms, err := loadActionSynonyms(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if synonyms["engage"] != "on" || synonyms["shut"] != "off" || synonyms["activate"] != "on" {
+		t.Errorf("synonyms %v, want engage added, shut overridden and the defaults kept", synonyms)
+	}
+
+	for name, content := range map[string]string{"malformed.json": `{"engage":`, "empty.json": `{"engage": " "}`} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadActionSynonyms(file); err == nil {
+			t.Errorf("loadActionSynonyms(%s) accepted %s", name, content)
+		}
+	}
+	if _, err := loadActionSynonyms(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("loadActionSynonyms accepted a missing file")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyContextKey is where apiKeyAuth stores the key it accepted.
+const apiKeyContextKey = "api_key"
+
+// apiKeyAuth rejects requests whose "Authorization: Bearer <key>" header
+// does not match one of keys. Keys are compared in constant time so the
+// response latency does not leak how much of a key was correct. The
+// accepted key is kept in the context for authenticatedKey.
+func apiKeyAuth(keys []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		key = strings.TrimSpace(key)
+		if !ok || !validAPIKey(keys, key) {
+			abortWithResponse(c, http.StatusUnauthorized, gin.H{responseError: "Missing or invalid API key"})
+			return
+		}
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// authenticatedKey returns the API key apiKeyAuth accepted for the
+// request, if any.
+func authenticatedKey(c *gin.Context) (string, bool) {
+	key := c.GetString(apiKeyContextKey)
+	return key, key != ""
+}
+
+func validAPIKey(keys []string, key string) bool {
+	valid := 0
+	for _, k := range keys {
+		valid |= subtle.ConstantTimeCompare([]byte(k), []byte(key))
+	}
+	return key != "" && valid == 1
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"firebase.google.com/go/v4/db"
+	"firebase.google.com/go/v4/errorutils"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+)
+
+const (
+	backendFirebase = "firebase"
+	backendMQTT     = "mqtt"
+)
+
+// DeviceBackend is the transport used to push device state. Command routing
+// only deals in paths such as "light1/turn"; the backend decides how a path
+// reaches the device.
+type DeviceBackend interface {
+	Set(ctx context.Context, path string, value interface{}) error
+}
+
+// multiSetter is implemented by backends that can write several paths in
+// one atomic update.
+type multiSetter interface {
+	SetMany(ctx context.Context, values map[string]interface{}) error
+}
+
+// StateStore is the database holding device state, the door owner flag,
+// health probes and the command history. It is always Firebase in
+// production, regardless of the device backend.
+type StateStore interface {
+	Get(ctx context.Context, path string, v interface{}) error
+	Set(ctx context.Context, path string, v interface{}) error
+	Push(ctx context.Context, path string, v interface{}) error
+}
+
+// backend and store are the active dependencies, set up in main. They are
+// package variables so that alternative implementations can be swapped in.
+var (
+	backend     DeviceBackend
+	backendName string
+	store       StateStore
+)
+
+func newBackend(cfg Config, fb *db.Client) (DeviceBackend, error) {
+	switch cfg.DeviceBackend {
+	case backendFirebase:
+		return firebaseBackend{client: fb, timeout: cfg.FirebaseTimeout}, nil
+	case backendMQTT:
+		return newMQTTBackend(cfg)
+	default:
+		return nil, errors.Errorf("unknown device backend %q", cfg.DeviceBackend)
+	}
+}
+
+// firebaseBackend writes device state to the Realtime Database. Every call
+// is bounded by timeout on top of the caller's context.
+type firebaseBackend struct {
+	client  *db.Client
+	timeout time.Duration
+}
+
+func (b firebaseBackend) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, b.timeout)
+}
+
+func (b firebaseBackend) Set(ctx context.Context, path string, value interface{}) error {
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	return permissionError(ctx, path, b.client.NewRef(path).Set(ctx, value))
+}
+
+// SetMany applies values with a multi-location update on the root, which
+// the Realtime Database commits atomically.
+func (b firebaseBackend) SetMany(ctx context.Context, values map[string]interface{}) error {
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	return permissionError(ctx, "/", b.client.NewRef("/").Update(ctx, values))
+}
+
+func (b firebaseBackend) Get(ctx context.Context, path string, v interface{}) error {
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	return permissionError(ctx, path, b.client.NewRef(path).Get(ctx, v))
+}
+
+func (b firebaseBackend) Push(ctx context.Context, path string, v interface{}) error {
+	ctx, cancel := b.withTimeout(ctx)
+	defer cancel()
+	_, err := b.client.NewRef(path).Push(ctx, v)
+	return permissionError(ctx, path, err)
+}
+
+// permissionError replaces an access denial from the Admin SDK with
+// ErrPermissionDenied, logging the path and the original error for
+// operators. The Realtime Database rejects a request its rules forbid
+// with 401 and a token lacking the IAM role with 403; both are caught.
+func permissionError(ctx context.Context, path string, err error) error {
+	if err == nil || !(errorutils.IsPermissionDenied(err) || errorutils.IsUnauthenticated(err)) {
+		return err
+	}
+	logFromContext(ctx).Error("database denied access", "path", path, "error", err)
+	return ErrPermissionDenied
+}
+
+// mqttBackend publishes device state as retained messages, using the device
+// path (optionally prefixed) as the topic.
+type mqttBackend struct {
+	client      mqtt.Client
+	topicPrefix string
+}
+
+func newMQTTBackend(cfg Config) (*mqttBackend, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.MQTTBrokerURL).
+		SetClientID(cfg.MQTTClientID).
+		SetUsername(cfg.MQTTUsername).
+		SetPassword(cfg.MQTTPassword).
+		SetAutoReconnect(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("MQTT connection lost", "error", err)
+		})
+
+	c := mqtt.NewClient(opts)
+	token := c.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return nil, errors.Errorf("timed out connecting to MQTT broker %s", cfg.MQTTBrokerURL)
+	}
+	if err := token.Error(); err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to MQTT broker %s", cfg.MQTTBrokerURL)
+	}
+	return &mqttBackend{client: c, topicPrefix: strings.TrimSuffix(cfg.MQTTTopicPrefix, "/")}, nil
+}
+
+func (b *mqttBackend) Set(ctx context.Context, path string, value interface{}) error {
+	topic := path
+	if b.topicPrefix != "" {
+		topic = b.topicPrefix + "/" + path
+	}
+
+	token := b.client.Publish(topic, 1, true, fmt.Sprint(value))
+	select {
+	case <-token.Done():
+		return errors.Wrapf(token.Error(), "failed to publish to %s", topic)
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "publishing to %s", topic)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fakeBackend is an in-memory DeviceBackend and StateStore that records
+// every read and write. Paths in fail return their error instead.
+type fakeBackend struct {
+	mu     sync.Mutex
+	state  map[string]interface{}
+	reads  []string
+	writes []deviceWrite
+	pushes map[string][]interface{}
+	fail   map[string]error
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		state:  make(map[string]interface{}),
+		pushes: make(map[string][]interface{}),
+		fail:   make(map[string]error),
+	}
+}
+
+func (f *fakeBackend) Set(_ context.Context, path string, value interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail[path]; err != nil {
+		return err
+	}
+	f.state[path] = value
+	f.writes = append(f.writes, deviceWrite{Path: path, Value: value})
+	return nil
+}
+
+func (f *fakeBackend) Get(_ context.Context, path string, v interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reads = append(f.reads, path)
+	if err := f.fail[path]; err != nil {
+		return err
+	}
+	data, err := json.Marshal(f.state[path])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (f *fakeBackend) Push(_ context.Context, path string, v interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pushes[path] = append(f.pushes[path], v)
+	return nil
+}
+
+// written returns the paths written so far, sorted.
+func (f *fakeBackend) written() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	paths := make([]string, 0, len(f.writes))
+	for _, w := range f.writes {
+		paths = append(paths, w.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// fakeOwner answers every owner verification with result.
+type fakeOwner OwnerResult
+
+func (o fakeOwner) Verify(context.Context) (OwnerResult, error) {
+	return OwnerResult(o), nil
+}
+
+// useFakeBackend installs a fake as the device backend and state store,
+// with the owner recognised, for the duration of the test.
+func useFakeBackend(t *testing.T) *fakeBackend {
+	t.Helper()
+	f := newFakeBackend()
+	oldBackend, oldStore, oldOwner := backend, store, ownerVerifier
+	backend, store, ownerVerifier = f, f, fakeOwner(OwnerAuthorized)
+	t.Cleanup(func() { backend, store, ownerVerifier = oldBackend, oldStore, oldOwner })
+	return f
+}
+
+func TestFirebasePermissionDenied(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		denied bool
+	}{
+		{name: "rules", status: http.StatusUnauthorized, denied: true},
+		{name: "IAM", status: http.StatusForbidden, denied: true},
+		{name: "server error", status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{"error":"Permission denied"}`))
+			}))
+			defer srv.Close()
+			// The Admin SDK only understands emulator hosts given by name.
+			host := strings.Replace(strings.TrimPrefix(srv.URL, "http://"), "127.0.0.1", "localhost", 1)
+			fb, err := initFirebase(Config{DatabaseURL: defaultDatabaseURL, EmulatorHost: host})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := firebaseBackend{client: fb, timeout: time.Second}
+			err = b.Set(context.Background(), "light1/turn", actionOn)
+			if denied := errors.Is(err, ErrPermissionDenied); denied != tt.denied {
+				t.Fatalf("Set() = %v, want permission denied %v", err, tt.denied)
+			}
+		})
+	}
+}
+
+func TestPermissionDeniedResponse(t *testing.T) {
+	f := useFakeBackend(t)
+	f.fail["light2/turn"] = ErrPermissionDenied
+	status, body := processAIResponse(context.Background(), AIResponse{Target: "light", Action: "on", Location: "bedroom"})
+	if status != http.StatusForbidden {
+		t.Fatalf("status %d, want 403: %v", status, body)
+	}
+	if msg, _ := body[responseError].(string); !strings.Contains(msg, "service account") {
+		t.Errorf("error %q does not point at the configuration", msg)
+	}
+}
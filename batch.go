@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxBatchInstructions = 20
+
+type batchRequest struct {
+	Instructions []string `json:"instructions"`
+	Model        string   `json:"model,omitempty"`
+	// CallbackURL optionally receives the result of every instruction, as
+	// for a single instruction.
+	CallbackURL string `json:"callbackUrl,omitempty" binding:"omitempty,url"`
+	// PIN authorizes the door commands of the batch as the owner.
+	PIN string `json:"pin,omitempty"`
+}
+
+// batchResult is the outcome of one instruction of a batch.
+type batchResult struct {
+	Instruction string `json:"instruction"`
+	Status      int    `json:"status"`
+	Result      gin.H  `json:"result"`
+}
+
+// handleBatch classifies and runs several instructions one after the other,
+// so a single-threaded model is never asked to handle them concurrently.
+// Every instruction gets its own status and a failure does not stop the
+// rest of the batch.
+func handleBatch(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req batchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			status, body := bindingErrorResponse(err)
+			respond(c, status, body)
+			return
+		}
+		if len(req.Instructions) == 0 || len(req.Instructions) > maxBatchInstructions {
+			respond(c, http.StatusBadRequest, gin.H{responseError: fmt.Sprintf("A batch must contain between 1 and %d instructions", maxBatchInstructions)})
+			return
+		}
+		if req.Model != "" && !cfg.modelAllowed(req.Model) {
+			respond(c, http.StatusBadRequest, gin.H{responseError: fmt.Sprintf("Model %q is not allowed", req.Model)})
+			return
+		}
+
+		callback := req.CallbackURL
+		if callback == "" {
+			callback = c.GetHeader(callbackHeader)
+		}
+		if err := checkCallbackURL(callback); err != nil {
+			respond(c, http.StatusBadRequest, gin.H{responseError: err.Error()})
+			return
+		}
+		c.Request = c.Request.WithContext(withOwnerPIN(withCallback(c.Request.Context(), callback), req.PIN))
+
+		dryRun := dryRunRequested(c)
+		results := make([]batchResult, 0, len(req.Instructions))
+		failed := 0
+		for _, instruction := range req.Instructions {
+			status, body := runInstruction(c, cfg, instruction, req.Model, dryRun)
+			if status >= http.StatusBadRequest {
+				failed++
+			}
+			results = append(results, batchResult{Instruction: instruction, Status: status, Result: body})
+		}
+		respond(c, http.StatusOK, gin.H{
+			"message": fmt.Sprintf("%d of %d instructions succeeded", len(results)-failed, len(results)),
+			"results": results,
+		})
+	}
+}
+
+// runInstruction classifies a single batch instruction and runs the command.
+func runInstruction(c *gin.Context, cfg Config, instruction, model string, dryRun bool) (int, gin.H) {
+	ctx := withModelOutput(c.Request.Context(), &modelOutput{})
+	instruction, response, status, body := classifyInstruction(ctx, cfg, Instruction{Instruction: instruction, Model: model})
+	if status != 0 {
+		return status, body
+	}
+	return recordCommand(withConfirmToken(ctx, c.GetHeader(confirmHeader)), instruction, response, dryRun)
+}
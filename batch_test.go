@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBatchChecksBodyCallback(t *testing.T) {
+	useWebhooks(t, newWebhookNotifier(Config{
+		WebhookAllowedHosts: []string{"hooks.example.com"},
+		WebhookSecret:       "s3cret",
+		WebhookMaxAttempts:  1,
+		WebhookTimeout:      time.Second,
+	}))
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/batch", handleBatch(Config{}))
+
+	body := `{"instructions": ["turn on the light"], "callbackUrl": "http://169.254.169.254/latest/meta-data"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want the callback in the body refused: %s", w.Code, w.Body)
+	}
+}
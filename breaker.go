@@ -0,0 +1,145 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrAIUnavailable is returned without calling the AI service while the
+// circuit breaker is open.
+var ErrAIUnavailable = errors.New("AI service is temporarily unavailable")
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// breakerStateValues are the values of the iot_ai_breaker_state gauge.
+var breakerStateValues = map[string]float64{breakerClosed: 0, breakerHalfOpen: 1, breakerOpen: 2}
+
+// circuitBreaker stops calling the AI service after threshold consecutive
+// failures. While open, calls fail fast for cooldown; then a single probe
+// is let through, which closes the breaker on success and reopens it on
+// failure. A nil *circuitBreaker lets every call through.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// aiBreaker guards the AI service; it is set up in main and nil when
+// disabled.
+var aiBreaker *circuitBreaker
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, state: breakerClosed}
+}
+
+// allow reports whether a call may go ahead. Every allowed call must be
+// followed by success, failure or release.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrAIUnavailable
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return ErrAIUnavailable
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.probing = 0, false
+	b.setState(breakerClosed)
+}
+
+func (b *circuitBreaker) failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+// release ends a call that says nothing about the service, such as one
+// cancelled by the client, so that another probe may run.
+func (b *circuitBreaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// setState must be called with b.mu held.
+func (b *circuitBreaker) setState(state string) {
+	if b.state != state {
+		b.state = state
+		aiBreakerState.Set(breakerStateValues[state])
+	}
+}
+
+// retryAfter is how long until an open breaker lets a probe through.
+func (b *circuitBreaker) retryAfter() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return 0
+	}
+	return max(b.cooldown-time.Since(b.openedAt), 0)
+}
+
+// status describes the breaker for the health endpoint.
+func (b *circuitBreaker) status() map[string]interface{} {
+	if b == nil {
+		return map[string]interface{}{"state": "disabled"}
+	}
+	retryIn := b.retryAfter()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := map[string]interface{}{"state": b.state, "consecutive_failures": b.failures}
+	if b.state == breakerOpen {
+		status["retry_in"] = retryIn.Round(time.Second).String()
+	}
+	return status
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Stages of a request reported when its budget runs out.
+const (
+	stageAI     = "ai"
+	stageDevice = "device"
+)
+
+// stageNames describe the stages in error messages.
+var stageNames = map[string]string{
+	stageAI:     "AI classification",
+	stageDevice: "the device update",
+}
+
+// ErrBudgetExhausted is matched by the cause of a request context whose
+// REQUEST_BUDGET ran out.
+var ErrBudgetExhausted = errors.New("Request time budget exhausted")
+
+// budgetError is the cause of a request context whose budget ran out.
+type budgetError struct {
+	Budget time.Duration
+}
+
+func (e *budgetError) Error() string {
+	return fmt.Sprintf("Request time budget of %s exhausted", e.Budget)
+}
+
+func (e *budgetError) Unwrap() error { return ErrBudgetExhausted }
+
+// withBudget returns ctx bounded by budget, so that classification and the
+// device writes share one deadline. A budget of zero sets none.
+func withBudget(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	if budget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, budget, &budgetError{Budget: budget})
+}
+
+// budgetFailure reports 504 Gateway Timeout naming stage when the budget of
+// ctx ran out, and ok=false otherwise.
+func budgetFailure(ctx context.Context, stage string) (status int, body gin.H, ok bool) {
+	var budgetErr *budgetError
+	if !errors.As(context.Cause(ctx), &budgetErr) {
+		return 0, nil, false
+	}
+	logFromContext(ctx).Warn("request budget exhausted", "budget", budgetErr.Budget.String(), "stage", stage)
+	return http.StatusGatewayTimeout, gin.H{
+		responseError: fmt.Sprintf("Request ran out of its %s time budget during %s", budgetErr.Budget, stageNames[stage]),
+		"stage":       stage,
+	}, true
+}
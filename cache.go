@@ -0,0 +1,96 @@
+package main
+
+import (
+	"container/list"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// responseCache is a fixed-size LRU of classified instructions whose
+// entries expire after ttl.
+type responseCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key      string
+	response AIResponse
+	expires  time.Time
+}
+
+// aiCache is nil when caching is disabled.
+var aiCache *responseCache
+
+func newResponseCache(size int, ttl time.Duration) *responseCache {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &responseCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// cacheKey normalizes an instruction so that case and spacing differences
+// share an entry. The model is part of the key because models may
+// classify the same instruction differently.
+func cacheKey(instruction, model string) string {
+	return model + "\x00" + strings.Join(strings.Fields(strings.ToLower(instruction)), " ")
+}
+
+func (rc *responseCache) get(key string) (AIResponse, bool) {
+	if rc == nil {
+		return AIResponse{}, false
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	el, ok := rc.entries[key]
+	if !ok {
+		aiCacheRequests.WithLabelValues("miss").Inc()
+		return AIResponse{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		rc.order.Remove(el)
+		delete(rc.entries, key)
+		aiCacheRequests.WithLabelValues("miss").Inc()
+		return AIResponse{}, false
+	}
+	rc.order.MoveToFront(el)
+	aiCacheRequests.WithLabelValues("hit").Inc()
+	response := entry.response
+	response.Locations = slices.Clone(response.Locations)
+	return response, true
+}
+
+func (rc *responseCache) put(key string, response AIResponse) {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	response.Locations = slices.Clone(response.Locations)
+	entry := &cacheEntry{key: key, response: response, expires: time.Now().Add(rc.ttl)}
+	if el, ok := rc.entries[key]; ok {
+		el.Value = entry
+		rc.order.MoveToFront(el)
+		return
+	}
+	rc.entries[key] = rc.order.PushFront(entry)
+	if rc.order.Len() > rc.size {
+		oldest := rc.order.Back()
+		rc.order.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
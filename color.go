@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// colorField is the path, next to turn, where RGB bulbs read their color as
+// a hex string such as "#0000FF".
+const colorField = "color"
+
+// colorActions are the light actions that set the color of the bulb. Any
+// light command carrying a color sets it too.
+var colorActions = map[string]bool{"color": true, "set color": true}
+
+// namedColors maps the color names understood in commands to the value
+// written to the bulb.
+var namedColors = map[string]string{
+	"red":        "#FF0000",
+	"green":      "#00FF00",
+	"blue":       "#0000FF",
+	"white":      "#FFFFFF",
+	"warm white": "#FFB46B",
+	"yellow":     "#FFFF00",
+	"orange":     "#FFA500",
+	"purple":     "#800080",
+	"pink":       "#FFC0CB",
+	"cyan":       "#00FFFF",
+	"magenta":    "#FF00FF",
+}
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// parseColor returns the hex value of a color name or of a "#RRGGBB"
+// string, in upper case.
+func parseColor(color string) (string, error) {
+	color = normalizeAction(color)
+	if strings.HasPrefix(color, "#") {
+		if !hexColor.MatchString(color) {
+			return "", errors.Errorf("Invalid color %q, expected #RRGGBB", color)
+		}
+		return strings.ToUpper(color), nil
+	}
+	if hex, ok := namedColors[color]; ok {
+		return hex, nil
+	}
+	if color == "" {
+		return "", errors.Errorf("Which color? Please name one of %s, or give #RRGGBB", strings.Join(colorNames(), ", "))
+	}
+	return "", errors.Errorf("Unknown color %q, expected one of %s, or #RRGGBB", color, strings.Join(colorNames(), ", "))
+}
+
+func colorNames() []string {
+	names := make([]string, 0, len(namedColors))
+	for name := range namedColors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setLightColor writes the color of the command to the bulbs of every
+// location.
+func setLightColor(ctx context.Context, response AIResponse) (int, gin.H) {
+	color, err := parseColor(response.Color)
+	if err != nil {
+		return http.StatusBadRequest, gin.H{responseError: err.Error(), "supported_colors": colorNames()}
+	}
+	locations := response.targetLocations()
+	results := updateRoomDevices(ctx, "light", colorField, locations, color)
+	return roomResultsResponse(results, fmt.Sprintf("Light color set to %s in %s", color, strings.Join(locations, ", ")))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Condition makes a command run only when one device is in the expected
+// state, as in "if the door is open, close it". Only equality on the turn
+// state of a light, fan, door or blind is supported; a blind counts as open
+// at any position above 0.
+type Condition struct {
+	Target   string `json:"target"`
+	Location string `json:"location"`
+	// ExpectedState is "on", "off", "open" or "close", or a synonym of one.
+	ExpectedState string `json:"expectedState"`
+}
+
+// conditionStates maps the past participles a model tends to use for a
+// state to the action that leads to it.
+var conditionStates = map[string]string{"opened": "open", "closed": "close"}
+
+// expectedValue returns the stored turn value the condition expects. Blinds
+// store a position rather than a turn value, so for them it is "open" or
+// "close" and matches compares it with the position.
+func (c Condition) expectedValue() (string, error) {
+	state := normalizeAction(c.ExpectedState)
+	if s, ok := conditionStates[state]; ok {
+		state = s
+	}
+	action := canonicalAction(c.Target, state)
+	if c.Target == "blind" {
+		if action != "open" && action != "close" {
+			return "", errors.Wrapf(ErrInvalidAction, "unsupported condition state %q", c.ExpectedState)
+		}
+		return action, nil
+	}
+	value, ok := switchValue(action)
+	if !ok {
+		return "", errors.Wrapf(ErrInvalidAction, "unsupported condition state %q", c.ExpectedState)
+	}
+	return value, nil
+}
+
+// matches reports whether the stored value current is the expected value.
+func (c Condition) matches(expected string, current interface{}) bool {
+	if current == nil {
+		return false
+	}
+	if c.Target == "blind" {
+		position, err := parseNumber("position", current)
+		if err != nil {
+			return false
+		}
+		return (position > 0) == (expected == "open")
+	}
+	return fmt.Sprint(current) == expected
+}
+
+// path returns the device path the condition reads. A door condition
+// without a location falls back to doorDefaultLocation.
+func (c Condition) path() (string, error) {
+	paths, ok := stateTargets(c.Target)
+	if !ok {
+		return "", errors.Wrapf(ErrUnsupportedTarget, "condition on unsupported target %q", c.Target)
+	}
+	location := normalizeLocation(c.Location)
+	if location == "" && c.Target == "door" {
+		location = doorDefaultLocation
+	}
+	path, ok := paths[location]
+	if !ok {
+		return "", errors.Wrapf(ErrInvalidLocation, "condition on unknown %s location %q", c.Target, c.Location)
+	}
+	return path, nil
+}
+
+func validateCondition(c Condition) error {
+	if _, err := c.path(); err != nil {
+		return err
+	}
+	_, err := c.expectedValue()
+	return err
+}
+
+// checkCondition reads the device named by c and reports whether it is in
+// the expected state, along with the state it was found in.
+func checkCondition(ctx context.Context, c Condition) (bool, interface{}, error) {
+	path, err := c.path()
+	if err != nil {
+		return false, nil, err
+	}
+	expected, err := c.expectedValue()
+	if err != nil {
+		return false, nil, err
+	}
+	current, err := readState(ctx, path)
+	if err != nil {
+		return false, nil, err
+	}
+	met := c.matches(expected, current)
+	logFromContext(ctx).Info("condition checked", "path", path, "expected", expected, "state", current, "met", met)
+	return met, current, nil
+}
+
+// conditionNotMet is the reply to a command whose condition does not hold.
+func conditionNotMet(c Condition, state interface{}) (int, gin.H) {
+	return http.StatusOK, gin.H{
+		"message":   "Condition not met, no action taken",
+		"condition": c,
+		"state":     state,
+	}
+}
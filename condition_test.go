@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestConditionalCommand(t *testing.T) {
+	closeDoorIfOpen := AIResponse{Target: "door", Action: "close", Location: "front", Condition: &Condition{Target: "door", ExpectedState: "open"}}
+	tests := []struct {
+		name       string
+		command    AIResponse
+		state      map[string]interface{}
+		wantStatus int
+		wantWrites int
+		wantMsg    string
+	}{
+		{name: "met", command: closeDoorIfOpen, state: map[string]interface{}{"door/turn": actionOn}, wantStatus: http.StatusOK, wantWrites: 1},
+		{name: "met with a number", command: closeDoorIfOpen, state: map[string]interface{}{"door/turn": 1}, wantStatus: http.StatusOK, wantWrites: 1},
+		{name: "not met", command: closeDoorIfOpen, state: map[string]interface{}{"door/turn": actionOff}, wantStatus: http.StatusOK, wantMsg: "Condition not met, no action taken"},
+		{name: "state missing", command: closeDoorIfOpen, wantStatus: http.StatusOK, wantMsg: "Condition not met, no action taken"},
+		{
+			name:       "past participle",
+			command:    AIResponse{Target: "light", Action: "off", Location: "kitchen", Condition: &Condition{Target: "door", Location: "front", ExpectedState: "closed"}},
+			state:      map[string]interface{}{"door/turn": actionOff},
+			wantStatus: http.StatusOK,
+			wantWrites: 1,
+		},
+		{
+			name:       "other device",
+			command:    AIResponse{Target: "fan", Action: "on", Location: "bedroom", Condition: &Condition{Target: "light", Location: "The Bedroom", ExpectedState: "on"}},
+			state:      map[string]interface{}{"light2/turn": actionOn},
+			wantStatus: http.StatusOK,
+			wantWrites: 1,
+		},
+		{
+			name:       "blind open",
+			command:    AIResponse{Target: "light", Action: "off", Location: "living room", Condition: &Condition{Target: "blind", Location: "living room", ExpectedState: "open"}},
+			state:      map[string]interface{}{"blind1/position": 40},
+			wantStatus: http.StatusOK,
+			wantWrites: 1,
+		},
+		{
+			name:       "blind closed",
+			command:    AIResponse{Target: "light", Action: "on", Location: "living room", Condition: &Condition{Target: "blind", Location: "living room", ExpectedState: "closed"}},
+			state:      map[string]interface{}{"blind1/position": 0},
+			wantStatus: http.StatusOK,
+			wantWrites: 1,
+		},
+		{
+			name:       "blind not closed",
+			command:    AIResponse{Target: "light", Action: "on", Location: "living room", Condition: &Condition{Target: "blind", Location: "living room", ExpectedState: "close"}},
+			state:      map[string]interface{}{"blind1/position": 100},
+			wantStatus: http.StatusOK,
+			wantMsg:    "Condition not met, no action taken",
+		},
+		{
+			name:       "blind unsupported state",
+			command:    AIResponse{Target: "light", Action: "on", Location: "living room", Condition: &Condition{Target: "blind", Location: "living room", ExpectedState: "purple"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown location",
+			command:    AIResponse{Target: "fan", Action: "on", Location: "bedroom", Condition: &Condition{Target: "light", Location: "garage", ExpectedState: "on"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unsupported state",
+			command:    AIResponse{Target: "fan", Action: "on", Location: "bedroom", Condition: &Condition{Target: "light", Location: "bedroom", ExpectedState: "purple"}},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeBackend(t)
+			for path, value := range tt.state {
+				f.state[path] = value
+			}
+			status, body := runCommand(context.Background(), tt.command)
+			if status != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if len(f.writes) != tt.wantWrites {
+				t.Errorf("writes %+v, want %d", f.writes, tt.wantWrites)
+			}
+			if tt.wantMsg != "" && body["message"] != tt.wantMsg {
+				t.Errorf("message %v, want %q", body["message"], tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,751 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultDatabaseURL        = "https://iot-grio9-52213-default-rtdb.asia-southeast1.firebasedatabase.app/"
+	defaultAIServiceURL       = "http://localhost:11434/api/generate"
+	defaultOpenAIURL          = "https://api.openai.com/v1/chat/completions"
+	defaultOllamaModel        = "phi3"
+	defaultOpenAIModel        = "gpt-4o-mini"
+	defaultServiceKey         = "./serviceAccountKey.json"
+	defaultPort               = ":3000"
+	defaultAITimeout          = 30 * time.Second
+	defaultAIAttempts         = 3
+	defaultAIBackoff          = 500 * time.Millisecond
+	defaultHealthTimeout      = 2 * time.Second
+	defaultShutdownTimeout    = 15 * time.Second
+	defaultMaxInstruction     = 500
+	defaultMaxStreamClients   = 32
+	defaultMaxBodyBytes       = 64 << 10
+	defaultRateLimitPerMinute = 60
+	defaultRateLimitBurst     = 10
+	defaultAICacheSize        = 256
+	defaultAICacheTTL         = 5 * time.Minute
+	defaultServiceName        = "go-service"
+	defaultConfirmTTL         = 2 * time.Minute
+	defaultDoorLocation       = "front"
+	defaultOwnerPath          = "camera/isOwner"
+	defaultIdempotencyTTL     = time.Hour
+	defaultFirebaseTimeout    = 10 * time.Second
+	defaultFirebaseAttempts   = 5
+	defaultFirebaseBackoff    = time.Second
+	defaultAIMaxIdleConns     = 100
+	defaultAIMaxIdlePerHost   = 16
+	defaultAIIdleConnTimeout  = 90 * time.Second
+	defaultBreakerThreshold   = 5
+	defaultBreakerCooldown    = 30 * time.Second
+	defaultAIMaxConcurrent    = 4
+	defaultAIMaxQueue         = 16
+	defaultDowngradeLatency   = 5 * time.Second
+	defaultRecoverLatency     = 2 * time.Second
+	defaultWebhookAttempts    = 3
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultLevelStep          = 10
+	defaultQueueWorkers       = 4
+	defaultQueueSize          = 100
+)
+
+// Config holds the runtime settings of the service. Every field can be
+// overridden from the environment; unset variables keep the defaults above.
+type Config struct {
+	DatabaseURL  string
+	CredFile     string
+	AIServiceURL string
+	// AIProvider selects the classification backend: "ollama" (default),
+	// "openai" for any OpenAI-compatible chat completions endpoint, or
+	// "mock" to answer from the AIMockFile table in tests.
+	AIProvider string
+	AIAPIKey   string
+	// AIModel is the default model; AIAllowedModels are the models a
+	// request may pick instead (the default is always allowed).
+	AIModel         string
+	AIAllowedModels []string
+	ListenPort      string
+	AITimeout       time.Duration
+	// AIMaxAttempts is the number of times a failing AI request is tried,
+	// waiting AIRetryBackoff (doubled after every attempt) in between.
+	AIMaxAttempts  int
+	AIRetryBackoff time.Duration
+	// AIBreakerThreshold is the number of consecutive failed AI requests
+	// that open the circuit breaker (0 disables it); it stays open for
+	// AIBreakerCooldown before a probe request is let through.
+	AIBreakerThreshold int
+	AIBreakerCooldown  time.Duration
+	// AIFallbackModel, when set, replaces the default model while the
+	// smoothed latency of the latter is above AIDowngradeLatency, until it
+	// drops below AIRecoverLatency.
+	AIFallbackModel    string
+	AIDowngradeLatency time.Duration
+	AIRecoverLatency   time.Duration
+	// AIMaxConcurrent caps the AI requests in flight (0 disables the cap);
+	// up to AIMaxQueue more wait for a slot and the rest get a 503.
+	AIMaxConcurrent int
+	AIMaxQueue      int
+	// AIMaxIdleConns and AIMaxIdleConnsPerHost bound the connections
+	// kept open to the AI service between requests, for AIIdleConnTimeout.
+	AIMaxIdleConns        int
+	AIMaxIdleConnsPerHost int
+	AIIdleConnTimeout     time.Duration
+	// AIStream makes the Ollama provider consume the streamed response and
+	// stop reading once it holds a complete JSON object.
+	AIStream bool
+	// AIOutputFormat is how the model answers: "text" (a JSON object in the
+	// text), "tool_calls" (a function call, requested from OpenAI-compatible
+	// providers) or "auto" (default) to accept either.
+	AIOutputFormat string
+	// AIDebugLog logs the full prompt payload and the raw model output at
+	// debug level, and lowers the log level so they are shown.
+	AIDebugLog bool
+	// LogRedactInstructions keeps the text of instructions out of the logs
+	// and traces, including the access log and the errors quoting them; the
+	// debug logs of AIDebugLog still show it.
+	LogRedactInstructions bool
+	// AICacheSize is the number of classified instructions kept in memory
+	// for AICacheTTL; a size of 0 disables the cache.
+	AICacheSize int
+	AICacheTTL  time.Duration
+	// HealthTimeout bounds the dependency checks done by /healthz.
+	HealthTimeout time.Duration
+	// FirebaseTimeout bounds every Realtime Database read and write, so a
+	// dead connection fails the request instead of hanging it.
+	FirebaseTimeout time.Duration
+	// RequestBudget bounds a whole /api request, classification and device
+	// writes together, on top of AITimeout and FirebaseTimeout. Zero
+	// leaves each stage to its own timeout.
+	RequestBudget time.Duration
+	// FirebaseInitAttempts is how many times startup tries to reach
+	// Firebase, waiting FirebaseInitBackoff (doubled after every attempt)
+	// in between, before giving up.
+	FirebaseInitAttempts int
+	FirebaseInitBackoff  time.Duration
+	// ShutdownTimeout is how long in-flight requests may take to drain
+	// after SIGINT/SIGTERM.
+	ShutdownTimeout time.Duration
+	// MaxInstructionLength caps the number of characters accepted in a
+	// natural-language instruction.
+	MaxInstructionLength int
+	// MaxBodyBytes caps the size of a request body; larger requests are
+	// refused with 413 before anything parses them.
+	MaxBodyBytes int
+	// MaxStreamClients caps the concurrent /ws/state connections.
+	MaxStreamClients int
+	// RateLimitPerMinute is the sustained number of API requests a client
+	// may make per minute (0 disables rate limiting), with bursts of up
+	// to RateLimitBurst requests.
+	RateLimitPerMinute int
+	RateLimitBurst     int
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies
+	// allowed to report the client address in X-Forwarded-For. With none,
+	// clients are identified by the address of their connection.
+	TrustedProxies []string
+	// OwnerMode selects who may operate the door: "camera" (default) trusts
+	// the flag the door camera stores at OwnerPath, "pin" a PIN sent with
+	// the command and checked against the bcrypt hash OwnerPINHash, and
+	// "camera_or_pin" lets a correct PIN override a camera that does not
+	// recognise the owner.
+	OwnerMode    string
+	OwnerPath    string
+	OwnerPINHash string
+	// ConfirmRisky makes risky commands, such as opening the door, answer
+	// 409 with a one-time token that must be sent back within ConfirmTTL.
+	ConfirmRisky bool
+	ConfirmTTL   time.Duration
+	// DoorDefaultLocation is the door a command without a location opens;
+	// with DoorRequireLocation such commands are rejected as ambiguous.
+	DoorDefaultLocation string
+	DoorRequireLocation bool
+	// AllowAllTargets lets a command address every room at once with the
+	// location "all". Scenes are not affected.
+	AllowAllTargets bool
+	// LevelStep is how much "increase" and "decrease" move the brightness
+	// of a light when the command gives no amount.
+	LevelStep int
+	// VerifyWrites are the targets whose device writes are read back and
+	// checked, "door" by default. An empty VERIFY_WRITES turns it off.
+	VerifyWrites []string
+	// QueueWorkers run the commands of async requests (?async=true), taken
+	// from a queue of up to QueueSize jobs. With no workers every request
+	// runs synchronously.
+	QueueWorkers int
+	QueueSize    int
+	// DeviceCooldown is the shortest time between two writes to the same
+	// device path; DeviceCooldowns overrides it per target. Writes inside
+	// the window are refused with 429 Too Many Requests.
+	DeviceCooldown  time.Duration
+	DeviceCooldowns map[string]time.Duration
+	// LocationAutocorrect is the largest edit distance at which an unknown
+	// room is taken to mean the nearest known one; 0 only suggests it.
+	LocationAutocorrect int
+	// WebhookURL is notified of every completed command that has no
+	// callback URL of its own. Payloads are signed with WebhookSecret, and
+	// a delivery is tried up to WebhookMaxAttempts times.
+	WebhookURL         string
+	WebhookSecret      string
+	WebhookMaxAttempts int
+	WebhookTimeout     time.Duration
+	// WebhookAllowedHosts are the hosts per-request callbacks may go to,
+	// besides the host of WebhookURL. Webhooks only reach public addresses
+	// unless WebhookAllowPrivate is set, for receivers on the home network.
+	WebhookAllowedHosts []string
+	WebhookAllowPrivate bool
+	// IdempotencyTTL is how long the reply to a request carrying an
+	// Idempotency-Key is replayed to retries.
+	IdempotencyTTL time.Duration
+	// EmulatorHost is the host:port of a local Realtime Database emulator.
+	// When set, every database call goes to the emulator and no credentials
+	// are needed.
+	EmulatorHost string
+	// GRPCPort is the address the gRPC API listens on; it is disabled when
+	// empty.
+	GRPCPort string
+	// TLSCertFile and TLSKeyFile are the PEM certificate and key the HTTP
+	// server speaks HTTPS (TLS 1.2 or later, with HTTP/2) with. Plain HTTP
+	// is served when both are empty.
+	TLSCertFile string
+	TLSKeyFile  string
+	// DevicesFile is an optional JSON file describing the rooms of every
+	// device; the built-in layout is used when it is empty.
+	DevicesFile string
+	// ScenesFile is an optional JSON file of named scenes, each a list of
+	// commands; the built-in scenes are used when it is empty.
+	ScenesFile string
+	// ZonesFile is an optional JSON file of zones, each grouping device
+	// paths of several rooms under a name used like a room.
+	ZonesFile string
+	// PromptTemplateFile is an optional text/template file replacing the
+	// built-in AI prompt.
+	PromptTemplateFile string
+	// ActionSynonymsFile is an optional JSON object of extra action
+	// synonyms, e.g. {"engage": "on"}.
+	ActionSynonymsFile string
+	// AIMockFile is the JSON table of canned responses used by the mock
+	// AI provider: [{"match": "bedroom light", "response": {...}}].
+	AIMockFile string
+	// AIFailoverFile is an optional JSON list of providers tried in order
+	// when the one of AIProvider fails.
+	AIFailoverFile string
+	// APIKeys are the bearer tokens accepted by the API. Authentication is
+	// disabled when none are configured.
+	APIKeys []string
+	// OTLPEndpoint is the OTLP/HTTP collector URL spans are exported to;
+	// tracing is disabled when it is empty.
+	OTLPEndpoint string
+	ServiceName  string
+
+	// DeviceBackend selects how device writes are delivered: "firebase"
+	// (default) or "mqtt". Reads always go through Firebase.
+	DeviceBackend   string
+	MQTTBrokerURL   string
+	MQTTClientID    string
+	MQTTUsername    string
+	MQTTPassword    string
+	MQTTTopicPrefix string
+}
+
+func loadConfig() (Config, error) {
+	cfg := Config{
+		DatabaseURL:     getEnv("FIREBASE_DB_URL", defaultDatabaseURL),
+		CredFile:        getEnv("FIREBASE_CRED_FILE", defaultServiceKey),
+		AIProvider:      getEnv("AI_PROVIDER", providerOllama),
+		AIAPIKey:        getEnv("AI_API_KEY", ""),
+		AIAllowedModels: getEnvList("AI_ALLOWED_MODELS"),
+		ListenPort:      getEnv("LISTEN_PORT", defaultPort),
+		DevicesFile:     getEnv("DEVICES_FILE", ""),
+		ScenesFile:      getEnv("SCENES_FILE", ""),
+		ZonesFile:       getEnv("ZONES_FILE", ""),
+		APIKeys:         getEnvList("API_KEYS"),
+		OTLPEndpoint:    getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		ServiceName:     getEnv("OTEL_SERVICE_NAME", defaultServiceName),
+
+		DeviceBackend:   getEnv("DEVICE_BACKEND", backendFirebase),
+		MQTTBrokerURL:   getEnv("MQTT_BROKER_URL", ""),
+		MQTTClientID:    getEnv("MQTT_CLIENT_ID", "go-service"),
+		MQTTUsername:    getEnv("MQTT_USERNAME", ""),
+		MQTTPassword:    getEnv("MQTT_PASSWORD", ""),
+		MQTTTopicPrefix: getEnv("MQTT_TOPIC_PREFIX", ""),
+	}
+	serviceURL, model := defaultAIServiceURL, defaultOllamaModel
+	if cfg.AIProvider == providerOpenAI {
+		serviceURL, model = defaultOpenAIURL, defaultOpenAIModel
+	}
+	cfg.AIServiceURL = getEnv("AI_SERVICE_URL", serviceURL)
+	cfg.AIModel = getEnv("AI_MODEL", model)
+	cfg.AIFallbackModel = getEnv("AI_FALLBACK_MODEL", "")
+	cfg.EmulatorHost = getEnv("FIREBASE_DATABASE_EMULATOR_HOST", "")
+	cfg.DoorDefaultLocation = getEnv("DOOR_DEFAULT_LOCATION", defaultDoorLocation)
+	if !strings.Contains(cfg.ListenPort, ":") {
+		cfg.ListenPort = ":" + cfg.ListenPort
+	}
+	cfg.PromptTemplateFile = getEnv("PROMPT_TEMPLATE_FILE", "")
+	cfg.AIMockFile = getEnv("AI_MOCK_FILE", "")
+	cfg.AIFailoverFile = getEnv("AI_FAILOVER_FILE", "")
+	cfg.ActionSynonymsFile = getEnv("ACTION_SYNONYMS_FILE", "")
+	cfg.AIOutputFormat = getEnv("AI_OUTPUT_FORMAT", outputAuto)
+	cfg.GRPCPort = getEnv("GRPC_PORT", "")
+	cfg.TLSCertFile = getEnv("TLS_CERT_FILE", "")
+	cfg.TLSKeyFile = getEnv("TLS_KEY_FILE", "")
+	cfg.WebhookURL = getEnv("WEBHOOK_URL", "")
+	cfg.WebhookSecret = getEnv("WEBHOOK_SECRET", "")
+	cfg.WebhookAllowedHosts = getEnvList("WEBHOOK_ALLOWED_HOSTS")
+	cfg.TrustedProxies = getEnvList("TRUSTED_PROXIES")
+	cfg.VerifyWrites = []string{"door"}
+	if _, ok := os.LookupEnv("VERIFY_WRITES"); ok {
+		cfg.VerifyWrites = getEnvList("VERIFY_WRITES")
+	}
+	if cfg.GRPCPort != "" && !strings.Contains(cfg.GRPCPort, ":") {
+		cfg.GRPCPort = ":" + cfg.GRPCPort
+	}
+
+	var err error
+	if cfg.AITimeout, err = getEnvDuration("AI_TIMEOUT", defaultAITimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIMaxAttempts, err = getEnvInt("AI_MAX_ATTEMPTS", defaultAIAttempts); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIRetryBackoff, err = getEnvDuration("AI_RETRY_BACKOFF", defaultAIBackoff); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIStream, err = getEnvBool("AI_STREAM", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIDebugLog, err = getEnvBool("AI_DEBUG_LOG", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.LogRedactInstructions, err = getEnvBool("LOG_REDACT_INSTRUCTIONS", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.AICacheSize, err = getEnvInt("AI_CACHE_SIZE", defaultAICacheSize); err != nil {
+		return Config{}, err
+	}
+	if cfg.AICacheTTL, err = getEnvDuration("AI_CACHE_TTL", defaultAICacheTTL); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIBreakerThreshold, err = getEnvInt("AI_BREAKER_THRESHOLD", defaultBreakerThreshold); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIBreakerCooldown, err = getEnvDuration("AI_BREAKER_COOLDOWN", defaultBreakerCooldown); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIDowngradeLatency, err = getEnvDuration("AI_DOWNGRADE_LATENCY", defaultDowngradeLatency); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIRecoverLatency, err = getEnvDuration("AI_RECOVER_LATENCY", defaultRecoverLatency); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIMaxConcurrent, err = getEnvInt("AI_MAX_CONCURRENT", defaultAIMaxConcurrent); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIMaxQueue, err = getEnvInt("AI_MAX_QUEUE", defaultAIMaxQueue); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIMaxIdleConns, err = getEnvInt("AI_MAX_IDLE_CONNS", defaultAIMaxIdleConns); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIMaxIdleConnsPerHost, err = getEnvInt("AI_MAX_IDLE_CONNS_PER_HOST", defaultAIMaxIdlePerHost); err != nil {
+		return Config{}, err
+	}
+	if cfg.AIIdleConnTimeout, err = getEnvDuration("AI_IDLE_CONN_TIMEOUT", defaultAIIdleConnTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.HealthTimeout, err = getEnvDuration("HEALTH_TIMEOUT", defaultHealthTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.ShutdownTimeout, err = getEnvDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.FirebaseTimeout, err = getEnvDuration("FIREBASE_TIMEOUT", defaultFirebaseTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.RequestBudget, err = getEnvDuration("REQUEST_BUDGET", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.FirebaseInitAttempts, err = getEnvInt("FIREBASE_INIT_ATTEMPTS", defaultFirebaseAttempts); err != nil {
+		return Config{}, err
+	}
+	if cfg.FirebaseInitBackoff, err = getEnvDuration("FIREBASE_INIT_BACKOFF", defaultFirebaseBackoff); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxInstructionLength, err = getEnvInt("MAX_INSTRUCTION_LENGTH", defaultMaxInstruction); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxBodyBytes, err = getEnvInt("MAX_BODY_BYTES", defaultMaxBodyBytes); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxStreamClients, err = getEnvInt("MAX_WS_CONNECTIONS", defaultMaxStreamClients); err != nil {
+		return Config{}, err
+	}
+	cfg.OwnerMode = getEnv("OWNER_MODE", ownerModeCamera)
+	cfg.OwnerPath = getEnv("OWNER_PATH", defaultOwnerPath)
+	cfg.OwnerPINHash = getEnv("OWNER_PIN_HASH", "")
+	if cfg.ConfirmRisky, err = getEnvBool("CONFIRM_RISKY_COMMANDS", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.ConfirmTTL, err = getEnvDuration("CONFIRM_TTL", defaultConfirmTTL); err != nil {
+		return Config{}, err
+	}
+	if cfg.DoorRequireLocation, err = getEnvBool("DOOR_REQUIRE_LOCATION", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.LevelStep, err = getEnvInt("LEVEL_STEP", defaultLevelStep); err != nil {
+		return Config{}, err
+	}
+	if cfg.DeviceCooldown, err = getEnvDuration("DEVICE_COOLDOWN", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.DeviceCooldowns, err = parseCooldowns(getEnvList("DEVICE_COOLDOWNS")); err != nil {
+		return Config{}, err
+	}
+	if cfg.LocationAutocorrect, err = getEnvInt("LOCATION_AUTOCORRECT", 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.QueueWorkers, err = getEnvInt("QUEUE_WORKERS", defaultQueueWorkers); err != nil {
+		return Config{}, err
+	}
+	if cfg.QueueSize, err = getEnvInt("QUEUE_SIZE", defaultQueueSize); err != nil {
+		return Config{}, err
+	}
+	if cfg.WebhookMaxAttempts, err = getEnvInt("WEBHOOK_MAX_ATTEMPTS", defaultWebhookAttempts); err != nil {
+		return Config{}, err
+	}
+	if cfg.WebhookTimeout, err = getEnvDuration("WEBHOOK_TIMEOUT", defaultWebhookTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.WebhookAllowPrivate, err = getEnvBool("WEBHOOK_ALLOW_PRIVATE", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.AllowAllTargets, err = getEnvBool("ALLOW_ALL_TARGETS", true); err != nil {
+		return Config{}, err
+	}
+	if cfg.IdempotencyTTL, err = getEnvDuration("IDEMPOTENCY_TTL", defaultIdempotencyTTL); err != nil {
+		return Config{}, err
+	}
+	if cfg.RateLimitPerMinute, err = getEnvInt("RATE_LIMIT_RPM", defaultRateLimitPerMinute); err != nil {
+		return Config{}, err
+	}
+	if cfg.RateLimitBurst, err = getEnvInt("RATE_LIMIT_BURST", defaultRateLimitBurst); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (cfg Config) validate() error {
+	if cfg.DatabaseURL == "" {
+		return errors.New("FIREBASE_DB_URL must not be empty")
+	}
+	if cfg.AIServiceURL == "" {
+		return errors.New("AI_SERVICE_URL must not be empty")
+	}
+	switch cfg.AIProvider {
+	case providerOllama, providerOpenAI:
+	case providerMock:
+		if cfg.AIMockFile == "" {
+			return errors.New("AI_MOCK_FILE is required with AI_PROVIDER=mock")
+		}
+	default:
+		return errors.Errorf("AI_PROVIDER must be %q, %q or %q", providerOllama, providerOpenAI, providerMock)
+	}
+	switch cfg.AIOutputFormat {
+	case outputAuto, outputText, outputToolCalls:
+	default:
+		return errors.Errorf("AI_OUTPUT_FORMAT must be %q, %q or %q", outputAuto, outputText, outputToolCalls)
+	}
+	if cfg.AIOutputFormat == outputToolCalls && cfg.AIStream {
+		return errors.New("AI_OUTPUT_FORMAT=tool_calls cannot be combined with AI_STREAM")
+	}
+	if cfg.AITimeout <= 0 {
+		return errors.New("AI_TIMEOUT must be positive")
+	}
+	if cfg.AIMaxAttempts < 1 {
+		return errors.New("AI_MAX_ATTEMPTS must be at least 1")
+	}
+	if cfg.AIRetryBackoff < 0 {
+		return errors.New("AI_RETRY_BACKOFF must not be negative")
+	}
+	if cfg.AICacheSize < 0 {
+		return errors.New("AI_CACHE_SIZE must not be negative")
+	}
+	if cfg.AICacheSize > 0 && cfg.AICacheTTL <= 0 {
+		return errors.New("AI_CACHE_TTL must be positive")
+	}
+	if cfg.AIBreakerThreshold < 0 {
+		return errors.New("AI_BREAKER_THRESHOLD must not be negative")
+	}
+	if cfg.AIBreakerThreshold > 0 && cfg.AIBreakerCooldown <= 0 {
+		return errors.New("AI_BREAKER_COOLDOWN must be positive")
+	}
+	if cfg.AIFallbackModel != "" && (cfg.AIRecoverLatency <= 0 || cfg.AIRecoverLatency > cfg.AIDowngradeLatency) {
+		return errors.New("AI_RECOVER_LATENCY must be positive and at most AI_DOWNGRADE_LATENCY")
+	}
+	if cfg.AIMaxConcurrent < 0 || cfg.AIMaxQueue < 0 {
+		return errors.New("AI_MAX_CONCURRENT and AI_MAX_QUEUE must not be negative")
+	}
+	if cfg.AIMaxIdleConns < 0 || cfg.AIMaxIdleConnsPerHost < 0 {
+		return errors.New("AI_MAX_IDLE_CONNS and AI_MAX_IDLE_CONNS_PER_HOST must not be negative")
+	}
+	if cfg.AIIdleConnTimeout < 0 {
+		return errors.New("AI_IDLE_CONN_TIMEOUT must not be negative")
+	}
+	if cfg.HealthTimeout <= 0 {
+		return errors.New("HEALTH_TIMEOUT must be positive")
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		return errors.New("SHUTDOWN_TIMEOUT must be positive")
+	}
+	if cfg.FirebaseTimeout <= 0 {
+		return errors.New("FIREBASE_TIMEOUT must be positive")
+	}
+	if cfg.RequestBudget < 0 {
+		return errors.New("REQUEST_BUDGET must not be negative")
+	}
+	if cfg.FirebaseInitAttempts < 1 {
+		return errors.New("FIREBASE_INIT_ATTEMPTS must be at least 1")
+	}
+	if cfg.FirebaseInitBackoff < 0 {
+		return errors.New("FIREBASE_INIT_BACKOFF must not be negative")
+	}
+	for _, target := range cfg.VerifyWrites {
+		if _, ok := targetActions[target]; !ok {
+			return errors.Errorf("VERIFY_WRITES names unknown target %q", target)
+		}
+	}
+	if cfg.WebhookURL != "" {
+		if _, err := parseWebhookURL(cfg.WebhookURL); err != nil {
+			return errors.Wrap(err, "WEBHOOK_URL")
+		}
+	}
+	if (cfg.WebhookURL != "" || len(cfg.WebhookAllowedHosts) > 0) && cfg.WebhookSecret == "" {
+		return errors.New("WEBHOOK_SECRET is required when WEBHOOK_URL or WEBHOOK_ALLOWED_HOSTS is set, so that receivers can verify the payloads")
+	}
+	if cfg.WebhookMaxAttempts < 1 {
+		return errors.New("WEBHOOK_MAX_ATTEMPTS must be at least 1")
+	}
+	if cfg.WebhookTimeout <= 0 {
+		return errors.New("WEBHOOK_TIMEOUT must be positive")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.DeviceCooldown < 0 {
+		return errors.New("DEVICE_COOLDOWN must not be negative")
+	}
+	for target, cooldown := range cfg.DeviceCooldowns {
+		if _, ok := targetActions[target]; !ok {
+			return errors.Errorf("DEVICE_COOLDOWNS names unknown target %q", target)
+		}
+		if cooldown < 0 {
+			return errors.Errorf("DEVICE_COOLDOWNS for %s must not be negative", target)
+		}
+	}
+	if cfg.LocationAutocorrect < 0 {
+		return errors.New("LOCATION_AUTOCORRECT must not be negative")
+	}
+	switch cfg.OwnerMode {
+	case ownerModeCamera, ownerModePIN, ownerModeCameraOrPIN:
+	default:
+		return errors.Errorf("OWNER_MODE must be %q, %q or %q", ownerModeCamera, ownerModePIN, ownerModeCameraOrPIN)
+	}
+	if cfg.OwnerMode != ownerModePIN {
+		if err := validatePath(cfg.OwnerPath); err != nil {
+			return errors.Wrap(err, "invalid OWNER_PATH")
+		}
+	}
+	if cfg.OwnerMode != ownerModeCamera {
+		if _, err := bcrypt.Cost([]byte(cfg.OwnerPINHash)); err != nil {
+			return errors.Errorf("OWNER_PIN_HASH must be a bcrypt hash with OWNER_MODE=%s; generate one with -hash-pin", cfg.OwnerMode)
+		}
+	}
+	if cfg.QueueWorkers < 0 {
+		return errors.New("QUEUE_WORKERS must not be negative")
+	}
+	if cfg.QueueWorkers > 0 && cfg.QueueSize < 1 {
+		return errors.New("QUEUE_SIZE must be at least 1")
+	}
+	if cfg.LevelStep < 1 || cfg.LevelStep > 100 {
+		return errors.New("LEVEL_STEP must be between 1 and 100")
+	}
+	if cfg.MaxInstructionLength < 1 {
+		return errors.New("MAX_INSTRUCTION_LENGTH must be at least 1")
+	}
+	if cfg.MaxBodyBytes < 1 {
+		return errors.New("MAX_BODY_BYTES must be at least 1")
+	}
+	if cfg.MaxStreamClients < 1 {
+		return errors.New("MAX_WS_CONNECTIONS must be at least 1")
+	}
+	if cfg.ConfirmRisky && cfg.ConfirmTTL <= 0 {
+		return errors.New("CONFIRM_TTL must be positive")
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		return errors.New("IDEMPOTENCY_TTL must be positive")
+	}
+	if cfg.RateLimitPerMinute < 0 {
+		return errors.New("RATE_LIMIT_RPM must not be negative")
+	}
+	if cfg.RateLimitPerMinute > 0 && cfg.RateLimitBurst < 1 {
+		return errors.New("RATE_LIMIT_BURST must be at least 1")
+	}
+	switch cfg.DeviceBackend {
+	case backendFirebase:
+	case backendMQTT:
+		if cfg.MQTTBrokerURL == "" {
+			return errors.New("MQTT_BROKER_URL must be set when DEVICE_BACKEND is mqtt")
+		}
+	default:
+		return errors.Errorf("DEVICE_BACKEND must be %q or %q", backendFirebase, backendMQTT)
+	}
+	if cfg.emulated() {
+		return nil
+	}
+	return checkCredentials(cfg.CredFile)
+}
+
+// emulated reports whether the service runs against the database emulator.
+func (cfg Config) emulated() bool {
+	return cfg.EmulatorHost != ""
+}
+
+// tlsEnabled reports whether the HTTP server serves HTTPS.
+func (cfg Config) tlsEnabled() bool {
+	return cfg.TLSCertFile != ""
+}
+
+// secretFields are the Config fields String redacts.
+var secretFields = map[string]bool{"CredFile": true, "AIAPIKey": true, "APIKeys": true, "MQTTPassword": true, "WebhookSecret": true, "OwnerPINHash": true}
+
+// urlFields are the Config fields holding URLs that may carry credentials
+// as user info, which String strips.
+var urlFields = map[string]bool{"DatabaseURL": true, "MQTTBrokerURL": true, "WebhookURL": true}
+
+// String lists every setting as Name=value, one per line, with the secrets
+// redacted: a set secret shows as [redacted], an unset one stays empty so
+// that a missing override is still visible.
+func (cfg Config) String() string {
+	var b strings.Builder
+	v := reflect.ValueOf(cfg)
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		fmt.Fprintf(&b, "%s=", field.Name)
+		switch {
+		case urlFields[field.Name] && !value.IsZero():
+			fmt.Fprintf(&b, "%s\n", stripUserInfo(value.String()))
+		case !secretFields[field.Name] || value.IsZero():
+			fmt.Fprintf(&b, "%v\n", value.Interface())
+		case value.Kind() == reflect.Slice:
+			fmt.Fprintf(&b, "[%d redacted]\n", value.Len())
+		default:
+			b.WriteString("[redacted]\n")
+		}
+	}
+	return b.String()
+}
+
+// stripUserInfo removes the user name and password from raw. A URL that
+// does not parse is redacted whole, as it may still hold them.
+func stripUserInfo(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[redacted]"
+	}
+	u.User = nil
+	return u.String()
+}
+
+// ErrMissingCredentials is returned when the Firebase service account key
+// does not exist, the usual first-run mistake.
+var ErrMissingCredentials = errors.New("Firebase credentials not found")
+
+// checkCredentials reports a missing or unreadable service account key with
+// instructions on how to provide one.
+func checkCredentials(file string) error {
+	_, err := os.Stat(file)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return errors.Wrapf(ErrMissingCredentials,
+			"no service account key at %q: download one from the Firebase console (Project settings > Service accounts) and save it there, or set FIREBASE_CRED_FILE to its path", file)
+	default:
+		return errors.Wrapf(err, "credential file %q is not readable (set FIREBASE_CRED_FILE)", file)
+	}
+}
+
+// modelAllowed reports whether a request may select the given model.
+func (cfg Config) modelAllowed(model string) bool {
+	return model == cfg.AIModel || slices.Contains(cfg.AIAllowedModels, model)
+}
+
+func (cfg Config) modelOrDefault(model string) string {
+	if model == "" {
+		return cfg.AIModel
+	}
+	return model
+}
+
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v := getEnv(key, "")
+	if v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid duration for %s", key)
+	}
+	return d, nil
+}
+
+func getEnvInt(key string, fallback int) (int, error) {
+	v := getEnv(key, "")
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid integer for %s", key)
+	}
+	return n, nil
+}
+
+func getEnvBool(key string, fallback bool) (bool, error) {
+	v := getEnv(key, "")
+	if v == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid boolean for %s", key)
+	}
+	return b, nil
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries.
+func getEnvList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(getEnv(key, ""), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
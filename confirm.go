@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const confirmHeader = "X-Confirm-Token"
+
+// confirmationStore keeps the one-time tokens handed out for risky
+// commands until they are used or expire.
+type confirmationStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	pending map[string]pendingConfirmation
+}
+
+type pendingConfirmation struct {
+	command string
+	expires time.Time
+}
+
+// confirmations is nil when risky commands run without confirmation.
+var confirmations *confirmationStore
+
+func newConfirmationStore(enabled bool, ttl time.Duration) *confirmationStore {
+	if !enabled {
+		return nil
+	}
+	return &confirmationStore{ttl: ttl, pending: make(map[string]pendingConfirmation)}
+}
+
+// riskyCommand reports whether a command is security relevant or affects
+// the whole house: opening or unlocking the door or switching everything
+// off. Actions are canonicalized first, so a synonym such as a scene step
+// unlocking the door is caught as well.
+func riskyCommand(r AIResponse) bool {
+	r = r.withCanonicalAction()
+	if r.Target == sceneTarget {
+		for _, step := range (*scenes.Load())[sceneName(r.Content)] {
+			if riskyCommand(step) {
+				return true
+			}
+		}
+		return false
+	}
+	if r.Target == "door" {
+		return r.Action == "open" || r.Action == "on" || r.Action == "unlock"
+	}
+	if r.Action != "off" {
+		return false
+	}
+	for _, location := range r.targetLocations() {
+		if normalizeLocation(location) == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmationKey identifies what a token confirms, so it cannot be
+// replayed for a different command.
+func confirmationKey(r AIResponse) string {
+	locations := make([]string, 0, len(r.targetLocations()))
+	for _, location := range r.targetLocations() {
+		locations = append(locations, normalizeLocation(location))
+	}
+	return r.Target + "|" + r.Action + "|" + strings.Join(locations, ",")
+}
+
+// issue returns a new token confirming command.
+func (s *confirmationStore) issue(command AIResponse) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for token, p := range s.pending {
+		if now.After(p.expires) {
+			delete(s.pending, token)
+		}
+	}
+	token := uuid.NewString()
+	s.pending[token] = pendingConfirmation{command: confirmationKey(command), expires: now.Add(s.ttl)}
+	return token
+}
+
+// consume reports whether token confirms command and invalidates it.
+func (s *confirmationStore) consume(token string, command AIResponse) bool {
+	if token == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pending[token]
+	if !ok || time.Now().After(p.expires) || p.command != confirmationKey(command) {
+		return false
+	}
+	delete(s.pending, token)
+	return true
+}
+
+// requireConfirmation returns the 409 reply asking the caller to repeat a
+// risky command with a confirmation token, or ok when the command may run.
+func requireConfirmation(ctx context.Context, command AIResponse) (status int, body gin.H, ok bool) {
+	if confirmations == nil || !riskyCommand(command) {
+		return 0, nil, true
+	}
+	if confirmations.consume(confirmTokenFromContext(ctx), command) {
+		logFromContext(ctx).Info("risky command confirmed", "target", command.Target, "action", command.Action)
+		return 0, nil, true
+	}
+	token := confirmations.issue(command)
+	return http.StatusConflict, gin.H{
+		responseError:   fmt.Sprintf("Confirm %s %s by repeating the request with the %s header", command.Target, command.Action, confirmHeader),
+		"confirm_token": token,
+		"expires_in":    confirmations.ttl.String(),
+		"command":       command,
+	}, false
+}
+
+type confirmTokenKey struct{}
+
+func withConfirmToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, confirmTokenKey{}, token)
+}
+
+func confirmTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(confirmTokenKey{}).(string)
+	return token
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCooldown is matched by writes refused because the device path was
+// written too recently.
+var ErrCooldown = errors.New("Device is cooling down")
+
+// cooldownError reports a write refused by the cooldown of path, with the
+// time left before the path may be written again.
+type cooldownError struct {
+	Path      string
+	Remaining time.Duration
+}
+
+func (e *cooldownError) Error() string {
+	return fmt.Sprintf("Device %s was changed too recently, retry in %s", e.Path, e.Remaining.Round(time.Millisecond))
+}
+
+func (e *cooldownError) Unwrap() error { return ErrCooldown }
+
+// deviceCooldown refuses a write to a path written less than the cooldown
+// of its target ago, so that a flood of toggles or scenes cannot make a
+// relay chatter. A nil *deviceCooldown lets every write through.
+type deviceCooldown struct {
+	fallback time.Duration
+	targets  map[string]time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// cooldowns is the active cooldown, set up in main.
+var cooldowns *deviceCooldown
+
+func newDeviceCooldown(fallback time.Duration, targets map[string]time.Duration) *deviceCooldown {
+	if fallback <= 0 && len(targets) == 0 {
+		return nil
+	}
+	return &deviceCooldown{fallback: fallback, targets: targets, last: make(map[string]time.Time)}
+}
+
+func (d *deviceCooldown) window(target string) time.Duration {
+	if w, ok := d.targets[target]; ok {
+		return w
+	}
+	return d.fallback
+}
+
+// reserve records a write to every path at once, or refuses them all with
+// a *cooldownError when one of them is still cooling down. A write counts
+// even if it then fails, since the device may have switched anyway.
+func (d *deviceCooldown) reserve(ctx context.Context, paths ...string) error {
+	if d == nil {
+		return nil
+	}
+	window := d.window(targetFromContext(ctx))
+	if window <= 0 {
+		return nil
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, path := range paths {
+		if remaining := window - now.Sub(d.last[path]); remaining > 0 {
+			logFromContext(ctx).Warn("device write refused during cooldown", "path", path, "remaining", remaining.String())
+			return &cooldownError{Path: path, Remaining: remaining}
+		}
+	}
+	for _, path := range paths {
+		d.last[path] = now
+	}
+	// Entries older than every window are no longer needed.
+	for path, at := range d.last {
+		if now.Sub(at) > d.longest() {
+			delete(d.last, path)
+		}
+	}
+	return nil
+}
+
+func (d *deviceCooldown) longest() time.Duration {
+	longest := d.fallback
+	for _, w := range d.targets {
+		longest = max(longest, w)
+	}
+	return longest
+}
+
+type targetKey struct{}
+
+// withTarget returns ctx carrying the target of the command being run, so
+// that the write layer can apply settings per target.
+func withTarget(ctx context.Context, target string) context.Context {
+	return context.WithValue(ctx, targetKey{}, target)
+}
+
+func targetFromContext(ctx context.Context) string {
+	target, _ := ctx.Value(targetKey{}).(string)
+	return target
+}
+
+// parseCooldowns reads DEVICE_COOLDOWNS entries such as "door=2s".
+func parseCooldowns(entries []string) (map[string]time.Duration, error) {
+	cooldowns := make(map[string]time.Duration, len(entries))
+	for _, entry := range entries {
+		target, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, errors.Errorf("invalid DEVICE_COOLDOWNS entry %q, expected target=duration", entry)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid DEVICE_COOLDOWNS duration for %s", target)
+		}
+		cooldowns[strings.TrimSpace(target)] = d
+	}
+	return cooldowns, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deviceInfo describes one controllable target for clients.
+type deviceInfo struct {
+	Actions   []string          `json:"actions"`
+	Locations []string          `json:"locations,omitempty"`
+	Paths     map[string]string `json:"paths,omitempty"`
+}
+
+// handleDevices lists every controllable target with its supported actions
+// and locations, derived from the active registry. The version changes
+// whenever the registry does, so clients can cache the list.
+func handleDevices(c *gin.Context) {
+	targets := make(map[string]deviceInfo, len(targetActions))
+	for target, actions := range targetActions {
+		info := deviceInfo{Actions: actions}
+		if paths, ok := stateTargets(target); ok {
+			info.Paths = paths
+			for location := range paths {
+				info.Locations = append(info.Locations, location)
+			}
+			sort.Strings(info.Locations)
+		}
+		targets[target] = info
+	}
+	respond(c, http.StatusOK, gin.H{"version": registryVersion(targets), "targets": targets})
+}
+
+// registryVersion is a short content hash of the device list.
+func registryVersion(targets map[string]deviceInfo) string {
+	data, _ := json.Marshal(targets)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
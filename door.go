@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// doorDefaultLocation is the door used when a command names none. When it
+// is empty such commands are rejected as ambiguous.
+var doorDefaultLocation = defaultDoorLocation
+
+var errDoorFailed = errors.New("Failed to update door status")
+
+// doorLocations returns the doors a command applies to, falling back to
+// doorDefaultLocation. It returns nil when no door can be chosen.
+func doorLocations(r AIResponse) []string {
+	var locations []string
+	for _, location := range r.targetLocations() {
+		if strings.TrimSpace(location) != "" {
+			locations = append(locations, location)
+		}
+	}
+	if len(locations) == 0 && doorDefaultLocation != "" {
+		locations = []string{doorDefaultLocation}
+	}
+	return locations
+}
+
+// processDoor writes value to field, the relay or the lock, of every
+// addressed door. The owner is verified separately for each door right
+// before it is written.
+func processDoor(ctx context.Context, response AIResponse, field, value string) (int, gin.H) {
+	locations := doorLocations(response)
+	if len(locations) == 0 {
+		return errorResponse(errors.Wrap(ErrInvalidLocation, "Which door? Please name the door"))
+	}
+	locations = expandLocations("door", locations)
+
+	results := make([]locationResult, 0, len(locations))
+	for _, location := range locations {
+		result := locationResult{Location: location, OK: true}
+		if err := operateDoor(ctx, location, field, value); err != nil {
+			result = locationResult{Location: location, Error: err.Error(), err: err}
+		}
+		results = append(results, result)
+	}
+	if len(results) == 1 && errors.Is(results[0].err, ErrNotOwner) {
+		return http.StatusOK, gin.H{"message": ErrNotOwner.Error()}
+	}
+	return roomResultsResponse(results, fmt.Sprintf("Door %s in %s", response.Action, strings.Join(locations, ", ")))
+}
+
+func operateDoor(ctx context.Context, location, field, value string) error {
+	paths, err := resolveRoomPaths("door", field, location)
+	if err != nil {
+		return err
+	}
+	result, err := ownerVerifier.Verify(ctx)
+	logFromContext(ctx).Info("owner verification", "door", location, "result", result.String(), "error", err)
+	switch result {
+	case OwnerAuthorized:
+	case OwnerDenied:
+		return ErrNotOwner
+	default:
+		return doorFailure(err)
+	}
+	for _, path := range paths {
+		if err := setValue(withOwnerVerified(ctx), path, value); err != nil {
+			return doorFailure(err)
+		}
+	}
+	return nil
+}
+
+// doorFailure hides the cause of a failed door operation from the caller,
+// except for timeouts, permission denials and cooldowns, which are kept so
+// they are reported as such.
+func doorFailure(err error) error {
+	if isTimeout(err) || errors.Is(err, ErrPermissionDenied) || errors.Is(err, ErrCooldown) {
+		return errors.WithMessage(err, errDoorFailed.Error())
+	}
+	return errDoorFailed
+}
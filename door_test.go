@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestDoorActions(t *testing.T) {
+	tests := []struct {
+		name       string
+		command    AIResponse
+		owner      OwnerResult
+		wantWrites map[string]interface{}
+	}{
+		{name: "open", command: AIResponse{Target: "door", Action: "open", Location: "front"}, owner: OwnerAuthorized, wantWrites: map[string]interface{}{"door/turn": actionOn}},
+		{name: "close", command: AIResponse{Target: "door", Action: "close", Location: "front"}, owner: OwnerAuthorized, wantWrites: map[string]interface{}{"door/turn": actionOff}},
+		{name: "lock", command: AIResponse{Target: "door", Action: "lock", Location: "front"}, owner: OwnerAuthorized, wantWrites: map[string]interface{}{"door/lock": actionOn}},
+		{name: "unlock", command: AIResponse{Target: "door", Action: "unlock", Location: "front"}, owner: OwnerAuthorized, wantWrites: map[string]interface{}{"door/lock": actionOff}},
+		{name: "lock without a location", command: AIResponse{Target: "door", Action: "lock"}, owner: OwnerAuthorized, wantWrites: map[string]interface{}{"door/lock": actionOn}},
+		{name: "lock needs owner", command: AIResponse{Target: "door", Action: "lock", Location: "front"}, owner: OwnerDenied},
+		{name: "unlock needs owner", command: AIResponse{Target: "door", Action: "unlock", Location: "front"}, owner: OwnerDenied},
+		{name: "open needs owner", command: AIResponse{Target: "door", Action: "open", Location: "front"}, owner: OwnerDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeBackend(t)
+			ownerVerifier = fakeOwner(tt.owner)
+			status, body := runCommand(context.Background(), tt.command)
+			if status != http.StatusOK {
+				t.Fatalf("status %d, want 200: %v", status, body)
+			}
+			if len(f.writes) != len(tt.wantWrites) {
+				t.Fatalf("writes %+v, want %v", f.writes, tt.wantWrites)
+			}
+			for _, w := range f.writes {
+				if want, ok := tt.wantWrites[w.Path]; !ok || w.Value != want {
+					t.Errorf("wrote %v to %s, want %v", w.Value, w.Path, tt.wantWrites)
+				}
+			}
+		})
+	}
+}
+
+func TestLockPathIsOwnerProtected(t *testing.T) {
+	for _, path := range []string{"door/turn", "door/lock", "door2/lock"} {
+		if !ownerProtected(path) {
+			t.Errorf("%s is not owner-protected", path)
+		}
+	}
+	if ownerProtected("light1/turn") {
+		t.Error("light1/turn is owner-protected")
+	}
+	// A lock write outside processDoor still needs the owner.
+	f := useFakeBackend(t)
+	if err := setValue(context.Background(), "door/lock", actionOff); err == nil || len(f.writes) != 0 {
+		t.Fatalf("unverified lock write: %v, %+v", err, f.writes)
+	}
+}
+
+func TestDoorKeywords(t *testing.T) {
+	tests := []struct {
+		instruction string
+		action      string
+	}{
+		{"lock the door", "lock"},
+		{"open the door", "open"},
+		{"close the front door", "close"},
+		{"khóa cửa", "lock"},
+	}
+	for _, tt := range tests {
+		got, ok := classifyKeywords(tt.instruction)
+		if !ok || got.Target != "door" || got.Action != tt.action {
+			t.Errorf("classifyKeywords(%q) = %+v, %v, want door %s", tt.instruction, got, ok, tt.action)
+		}
+	}
+}
+
+func TestUnlockIsRisky(t *testing.T) {
+	for action, risky := range map[string]bool{"unlock": true, "open": true, "lock": false, "close": false} {
+		if got := riskyCommand(AIResponse{Target: "door", Action: action}); got != risky {
+			t.Errorf("riskyCommand(door %s) = %v, want %v", action, got, risky)
+		}
+	}
+}
+
+func TestRiskySceneSynonyms(t *testing.T) {
+	prevScenes, prevSynonyms := scenes.Load(), actionSynonyms.Load()
+	t.Cleanup(func() {
+		scenes.Store(prevScenes)
+		actionSynonyms.Store(prevSynonyms)
+	})
+	synonyms := map[string]string{"unbolt": "open", "release": "open", "disable": "off"}
+	actionSynonyms.Store(&synonyms)
+	scenes.Store(&SceneRegistry{
+		"arrive":   {{Target: "light", Action: "on", Location: "all"}, {Target: "door", Action: "unbolt", Location: "front"}},
+		"welcome":  {{Target: "door", Action: "release", Location: "back"}},
+		"blackout": {{Target: "light", Action: "disable", Location: "all"}},
+		"evening":  {{Target: "light", Action: "disable", Location: "kitchen"}},
+	})
+	for name, risky := range map[string]bool{"arrive": true, "welcome": true, "blackout": true, "evening": false} {
+		if got := riskyCommand(AIResponse{Target: sceneTarget, Action: "run", Content: name}); got != risky {
+			t.Errorf("riskyCommand(scene %s) = %v, want %v", name, got, risky)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySmoothing is the weight of the newest latency in the moving
+	// average; older requests fade out exponentially.
+	latencySmoothing = 0.2
+	// downgradeProbeEvery is how often, while downgraded, a request is
+	// still sent to the primary model to learn whether it has recovered.
+	downgradeProbeEvery = 10
+)
+
+// modelDowngrader switches requests for the default model to a lighter
+// model while the default one is slow. It keeps an exponentially smoothed
+// average of the primary model's latency: above threshold the fallback
+// model takes over, and once the average drops below recover the primary
+// model is used again. While downgraded, every downgradeProbeEvery-th
+// request still goes to the primary model so that its average keeps
+// moving. A nil *modelDowngrader never downgrades.
+type modelDowngrader struct {
+	primary   string
+	fallback  string
+	threshold time.Duration
+	recover   time.Duration
+
+	mu         sync.Mutex
+	average    time.Duration
+	downgraded bool
+	requests   int
+}
+
+// aiDowngrade is the active downgrader, set up in main and nil when no
+// fallback model is configured.
+var aiDowngrade *modelDowngrader
+
+func newModelDowngrader(cfg Config) *modelDowngrader {
+	if cfg.AIFallbackModel == "" {
+		return nil
+	}
+	d := &modelDowngrader{
+		primary:   cfg.AIModel,
+		fallback:  cfg.AIFallbackModel,
+		threshold: cfg.AIDowngradeLatency,
+		recover:   cfg.AIRecoverLatency,
+	}
+	d.setActiveMetric(d.primary)
+	return d
+}
+
+// choose returns the model to use for a request asking for model. Only
+// requests for the default model are downgraded.
+func (d *modelDowngrader) choose(model string) string {
+	if d == nil || (model != "" && model != d.primary) {
+		return model
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.downgraded {
+		return model
+	}
+	d.requests++
+	if d.requests%downgradeProbeEvery == 0 {
+		return d.primary
+	}
+	return d.fallback
+}
+
+// observe records the latency of a request sent to model.
+func (d *modelDowngrader) observe(model string, latency time.Duration) {
+	if d == nil || (model != "" && model != d.primary) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.average == 0 {
+		d.average = latency
+	} else {
+		d.average = time.Duration(latencySmoothing*float64(latency) + (1-latencySmoothing)*float64(d.average))
+	}
+	aiPrimaryLatency.Set(d.average.Seconds())
+
+	switch {
+	case !d.downgraded && d.average > d.threshold:
+		d.downgraded, d.requests = true, 0
+		d.setActiveMetric(d.fallback)
+		slog.Warn("AI model is slow, switching to the fallback model",
+			"model", d.primary, "fallback", d.fallback, "average_latency", d.average.String(), "threshold", d.threshold.String())
+	case d.downgraded && d.average < d.recover:
+		d.downgraded = false
+		d.setActiveMetric(d.primary)
+		slog.Info("AI model latency recovered, switching back",
+			"model", d.primary, "average_latency", d.average.String())
+	}
+}
+
+func (d *modelDowngrader) setActiveMetric(active string) {
+	aiActiveModel.Reset()
+	aiActiveModel.WithLabelValues(active).Set(1)
+}
+
+// status describes the downgrader for the health endpoint.
+func (d *modelDowngrader) status() map[string]interface{} {
+	if d == nil {
+		return map[string]interface{}{"downgrade": "disabled"}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	active := d.primary
+	if d.downgraded {
+		active = d.fallback
+	}
+	return map[string]interface{}{
+		"active":          active,
+		"primary":         d.primary,
+		"fallback":        d.fallback,
+		"downgraded":      d.downgraded,
+		"average_latency": d.average.Round(time.Millisecond).String(),
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const dryRunHeader = "X-Dry-Run"
+
+type dryRunKey struct{}
+
+func withDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// isDryRun reports whether device writes made with ctx must be skipped.
+func isDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// dryRunRequested reports whether the request asked for a dry run, either
+// with ?dryRun=true or an X-Dry-Run: true header.
+func dryRunRequested(c *gin.Context) bool {
+	for _, v := range []string{c.Query("dryRun"), c.GetHeader(dryRunHeader)} {
+		if dryRun, err := strconv.ParseBool(v); err == nil && dryRun {
+			return true
+		}
+	}
+	return false
+}
+
+// dryRunCommand resolves the command exactly like a real run but skips the
+// device writes, returning the paths and values that would have been
+// written. Delayed commands are resolved immediately instead of being
+// scheduled, and nothing is stored in the history.
+func dryRunCommand(ctx context.Context, response AIResponse) (int, gin.H) {
+	rec := &commandRecorder{}
+	ctx = withDryRun(withRecorder(ctx, rec))
+	var delay time.Duration
+	if response.Delay != nil {
+		var err error
+		if delay, err = parseDelay(response.Delay); err != nil {
+			return http.StatusBadRequest, gin.H{responseError: err.Error()}
+		}
+	}
+
+	status, body := runCommand(ctx, response)
+	body["dry_run"] = true
+	body["command"] = response
+	rec.mu.Lock()
+	body["writes"] = rec.writes
+	rec.mu.Unlock()
+	if delay > 0 {
+		body["scheduled_in"] = delay.String()
+	}
+	return status, body
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Sentinel errors returned while resolving a command. Callers match them
+// with errors.Is to pick the HTTP status.
+var (
+	ErrInvalidLocation   = errors.New("Invalid location")
+	ErrInvalidAction     = errors.New("Invalid action")
+	ErrUnsupportedTarget = errors.New("Unsupported target")
+	ErrNotOwner          = errors.New("You are not the owner")
+	ErrUnknownScene      = errors.New("Unknown scene")
+	ErrAllLocations      = errors.New("Commands for all rooms are disabled; please name the rooms")
+)
+
+// ErrPermissionDenied is returned when the database refuses the service
+// access to a path. It points at a misconfigured service account or
+// security rules rather than at the command, so it is reported as 403
+// Forbidden with the underlying error kept out of the response.
+var ErrPermissionDenied = errors.New("The service is not allowed to access the database; check its service account and database rules")
+
+// actionError reports an action the target does not support. It carries the
+// supported actions so the caller can ask the user to rephrase.
+type actionError struct {
+	Target    string
+	Action    string
+	Supported []string
+}
+
+func unsupportedAction(r AIResponse) error {
+	return &actionError{Target: r.Target, Action: r.Action, Supported: targetActions[r.Target]}
+}
+
+func (e *actionError) Error() string {
+	return fmt.Sprintf("Unsupported action %q for %s", e.Action, e.Target)
+}
+
+func (e *actionError) Unwrap() error { return ErrInvalidAction }
+
+// isClientError reports whether err was caused by the command itself rather
+// than by a failing dependency.
+func isClientError(err error) bool {
+	return errors.Is(err, ErrInvalidLocation) ||
+		errors.Is(err, ErrInvalidAction) ||
+		errors.Is(err, ErrUnsupportedTarget) ||
+		errors.Is(err, ErrNotOwner) ||
+		errors.Is(err, ErrUnknownScene) ||
+		errors.Is(err, ErrAllLocations) ||
+		errors.Is(err, ErrReadOnly)
+}
+
+// errorStatus maps err to the HTTP status reported to the caller.
+func errorStatus(err error) int {
+	var actionErr *actionError
+	if errors.As(err, &actionErr) {
+		return http.StatusUnprocessableEntity
+	}
+	if isClientError(err) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, ErrPermissionDenied) {
+		return http.StatusForbidden
+	}
+	if errors.Is(err, ErrCooldown) {
+		return http.StatusTooManyRequests
+	}
+	if isTimeout(err) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// writeFailure reports a failed device write with message, as 504 Gateway
+// Timeout when the database did not answer within its deadline, as 403
+// Forbidden when it denied access and as 429 Too Many Requests when the
+// device is cooling down.
+func writeFailure(err error, message string) (int, gin.H) {
+	var cooldownErr *cooldownError
+	if errors.As(err, &cooldownErr) {
+		return http.StatusTooManyRequests, gin.H{responseError: message + ": " + err.Error(), "retry_after": retryAfterSeconds(cooldownErr.Remaining)}
+	}
+	if errors.Is(err, ErrPermissionDenied) {
+		return http.StatusForbidden, gin.H{responseError: message + ": " + ErrPermissionDenied.Error()}
+	}
+	if isTimeout(err) {
+		return http.StatusGatewayTimeout, gin.H{responseError: message + ": the database did not respond in time"}
+	}
+	return http.StatusInternalServerError, gin.H{responseError: message}
+}
+
+// commandErrorResponse reports a command rejected by validateAIResponse.
+// Every such error is the caller's fault.
+func commandErrorResponse(err error) (int, gin.H) {
+	status, body := errorResponse(err)
+	if status == http.StatusInternalServerError {
+		status = http.StatusBadRequest
+	}
+	return status, body
+}
+
+// errorResponse builds the status and body reporting err.
+func errorResponse(err error) (int, gin.H) {
+	body := gin.H{responseError: err.Error()}
+	var actionErr *actionError
+	if errors.As(err, &actionErr) {
+		body["target"] = actionErr.Target
+		body["supported_actions"] = actionErr.Supported
+	}
+	var locErr *locationError
+	if errors.As(err, &locErr) {
+		body["known_locations"] = locErr.Known
+		if locErr.Suggestion != "" {
+			body["suggestion"] = locErr.Suggestion
+		}
+	}
+	var cooldownErr *cooldownError
+	if errors.As(err, &cooldownErr) {
+		body["retry_after"] = retryAfterSeconds(cooldownErr.Remaining)
+	}
+	return errorStatus(err), body
+}
+
+// retryAfterSeconds rounds d up to whole seconds for a retry_after field.
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
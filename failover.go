@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// providerSpec is an AI provider of AI_FAILOVER_FILE. URL and Model default
+// like AI_SERVICE_URL and AI_MODEL do for the provider, and the API key is
+// read from the variable named by APIKeyEnv so that the file holds no
+// secret.
+type providerSpec struct {
+	Name      string `json:"name"`
+	Provider  string `json:"provider"`
+	URL       string `json:"url"`
+	Model     string `json:"model"`
+	APIKeyEnv string `json:"apiKeyEnv"`
+}
+
+// namedProvider is a provider together with the name it is reported under.
+type namedProvider struct {
+	name string
+	AIProvider
+}
+
+// failoverProvider asks its providers in order and returns the first
+// answer. A provider failing or timing out hands the instruction to the
+// next one; a command the model understood but the service rejects does
+// not, since another model would not do better.
+type failoverProvider struct {
+	providers []namedProvider
+}
+
+func (f failoverProvider) Classify(ctx context.Context, instruction, model string) (AIResponse, error) {
+	var err error
+	for i, p := range f.providers {
+		// A model picked by the client, or by the downgrade, belongs to
+		// the first provider; the others use their own.
+		if i > 0 {
+			model = ""
+		}
+		var response AIResponse
+		if response, err = p.Classify(ctx, instruction, model); err == nil {
+			aiProviderRequests.WithLabelValues(p.name).Inc()
+			return response, nil
+		}
+		if isClientError(err) || ctx.Err() != nil || len(f.providers) == 1 {
+			return AIResponse{}, err
+		}
+		if i+1 < len(f.providers) {
+			logFromContext(ctx).Warn("AI provider failed, failing over", "provider", p.name, "next", f.providers[i+1].name, "error", err)
+			aiFailovers.Inc()
+		}
+	}
+	return AIResponse{}, errors.Wrapf(err, "all %d AI providers failed", len(f.providers))
+}
+
+// newFailoverProvider puts the provider of cfg, guarded by aiBreaker, in
+// front of the providers of cfg.AIFailoverFile. The failover providers
+// have no circuit breaker: the breaker of the primary already makes them
+// take over at once while it is open.
+func newFailoverProvider(cfg Config) (AIProvider, error) {
+	primary, err := newAIProvider(cfg, aiBreaker)
+	if err != nil {
+		return nil, err
+	}
+	providers := []namedProvider{{name: cfg.AIProvider, AIProvider: primary}}
+	if file := cfg.AIFailoverFile; file != "" {
+		specs, err := loadProviderSpecs(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, spec := range specs {
+			p, err := newAIProvider(spec.config(cfg), nil)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid AI failover file %s: provider %s", file, spec.Name)
+			}
+			providers = append(providers, namedProvider{name: spec.Name, AIProvider: p})
+		}
+	}
+	return failoverProvider{providers: providers}, nil
+}
+
+func loadProviderSpecs(file string) ([]providerSpec, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read AI failover file")
+	}
+	var specs []providerSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse AI failover file %s", file)
+	}
+	for i := range specs {
+		spec := &specs[i]
+		switch spec.Provider {
+		case providerOllama, providerOpenAI:
+		default:
+			return nil, errors.Errorf("invalid AI failover file %s: provider %d must be %q or %q", file, i+1, providerOllama, providerOpenAI)
+		}
+		if spec.Name == "" {
+			spec.Name = spec.Provider
+		}
+	}
+	return specs, nil
+}
+
+// config returns cfg with the AI settings of the spec.
+func (spec providerSpec) config(cfg Config) Config {
+	cfg.AIProvider = spec.Provider
+	cfg.AIServiceURL, cfg.AIModel = defaultAIServiceURL, defaultOllamaModel
+	if spec.Provider == providerOpenAI {
+		cfg.AIServiceURL, cfg.AIModel = defaultOpenAIURL, defaultOpenAIModel
+	}
+	if spec.URL != "" {
+		cfg.AIServiceURL = spec.URL
+	}
+	if spec.Model != "" {
+		cfg.AIModel = spec.Model
+	}
+	cfg.AIAPIKey = ""
+	if spec.APIKeyEnv != "" {
+		cfg.AIAPIKey = os.Getenv(spec.APIKeyEnv)
+	}
+	return cfg
+}
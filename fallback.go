@@ -0,0 +1,124 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// keywordRule maps a regular expression over the lowercased instruction to
+// the value it stands for.
+type keywordRule struct {
+	pattern *regexp.Regexp
+	value   string
+}
+
+// The English and Vietnamese keywords are matched as whole words. \b only
+// knows ASCII letters, so the rules use phrase, which also treats
+// Vietnamese letters such as "đ" as part of a word. Vietnamese keywords
+// need their diacritics: without them, words like "den" and "bat" are
+// English too.
+var (
+	fallbackActions = []keywordRule{
+		{phrase(`on|bật`), "on"},
+		{phrase(`off|tắt`), "off"},
+		{phrase(`open|mở`), "open"},
+		{phrase(`close|shut|đóng`), "close"},
+		{phrase(`lock|khóa`), "lock"},
+		{phrase(`unlock`), "unlock"},
+	}
+	fallbackTargets = []keywordRule{
+		{phrase(`lights?|lamps?|đèn`), "light"},
+		{phrase(`fans?|quạt`), "fan"},
+		{phrase(`door|cửa`), "door"},
+		{phrase(`blinds?|curtains?|rèm`), "blind"},
+	}
+	fallbackAll = phrase(`all|every|everywhere|whole house|tất cả|tat ca|cả nhà|ca nha`)
+)
+
+// phrase compiles a regular expression matching alternatives only as whole
+// words of any script.
+func phrase(alternatives string) *regexp.Regexp {
+	return regexp.MustCompile(`(?:^|[^\pL\pN])(?:` + alternatives + `)(?:[^\pL\pN]|$)`)
+}
+
+// classifyKeywords is a rule-based stand-in for the model that understands
+// the common on/off/open/close commands. It only answers when exactly one
+// action and one target match, and a room is named for room-based
+// targets, so an unclear instruction is never guessed at.
+func classifyKeywords(instruction string) (AIResponse, bool) {
+	text := strings.ToLower(instruction)
+	action, ok := matchSingle(fallbackActions, text)
+	if !ok {
+		return AIResponse{}, false
+	}
+	target, ok := matchSingle(fallbackTargets, text)
+	if !ok {
+		return AIResponse{}, false
+	}
+
+	response := AIResponse{Target: target, Action: action}
+	if rooms, roomBased := (*registry.Load())[target]; roomBased {
+		// Doors fall back to the default door when none is named.
+		if response.Location, ok = matchRoom(target, rooms, text); !ok && target != "door" {
+			return AIResponse{}, false
+		}
+	}
+	if validateAIResponse(response) != nil {
+		return AIResponse{}, false
+	}
+	return response, true
+}
+
+// matchSingle returns the value of the only rule matching text.
+func matchSingle(rules []keywordRule, text string) (string, bool) {
+	var match string
+	for _, rule := range rules {
+		if !rule.pattern.MatchString(text) {
+			continue
+		}
+		if match != "" {
+			return "", false
+		}
+		match = rule.value
+	}
+	return match, match != ""
+}
+
+// matchRoom returns the room whose name, or one of its synonyms, is the
+// longest mentioned in text, a zone of target, or "all".
+func matchRoom(target string, rooms map[string]string, text string) (string, bool) {
+	names := make(map[string]string, len(rooms))
+	for room := range rooms {
+		names[room] = room
+	}
+	for _, zone := range (*zones.Load()).names() {
+		if _, ok := (*zones.Load()).rooms(target, zone); ok {
+			names[zone] = zone
+		}
+	}
+	for synonym, room := range locationSynonyms {
+		if _, ok := rooms[room]; ok {
+			names[synonym] = room
+		}
+	}
+	mentions := make([]string, 0, len(names))
+	for name := range names {
+		mentions = append(mentions, name)
+	}
+	sort.Slice(mentions, func(i, j int) bool {
+		if len(mentions[i]) != len(mentions[j]) {
+			return len(mentions[i]) > len(mentions[j])
+		}
+		return mentions[i] < mentions[j]
+	})
+	for _, mention := range mentions {
+		if phrase(regexp.QuoteMeta(mention)).MatchString(text) {
+			return names[mention], true
+		}
+	}
+	if fallbackAll.MatchString(text) {
+		return "all", true
+	}
+	return "", false
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestClassifyKeywordsVietnamese(t *testing.T) {
+	tests := []struct {
+		instruction string
+		want        AIResponse
+		ok          bool
+	}{
+		{instruction: "bật đèn phòng khách", want: AIResponse{Target: "light", Action: "on", Location: "living room"}, ok: true},
+		{instruction: "Tắt quạt nhà bếp", want: AIResponse{Target: "fan", Action: "off", Location: "kitchen"}, ok: true},
+		{instruction: "tắt đèn phòng ngủ đi", want: AIResponse{Target: "light", Action: "off", Location: "bedroom"}, ok: true},
+		{instruction: "turn on the kitchen light", want: AIResponse{Target: "light", Action: "on", Location: "kitchen"}, ok: true},
+		// Without diacritics, "bat" and "den" are not keywords.
+		{instruction: "bat den phong khach"},
+		{instruction: "bật đèn"},
+		{instruction: "bật tắt đèn phòng khách"},
+	}
+	for _, tt := range tests {
+		got, ok := classifyKeywords(tt.instruction)
+		if ok != tt.ok {
+			t.Errorf("classifyKeywords(%q) ok = %v, want %v (%+v)", tt.instruction, ok, tt.ok, got)
+			continue
+		}
+		if ok && (got.Target != tt.want.Target || got.Action != tt.want.Action || got.Location != tt.want.Location) {
+			t.Errorf("classifyKeywords(%q) = %+v, want %+v", tt.instruction, got, tt.want)
+		}
+	}
+}
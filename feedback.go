@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// feedback is what a client reports about a classified command.
+type feedback struct {
+	Correct   bool   `json:"correct"`
+	Comment   string `json:"comment,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type feedbackRequest struct {
+	RequestID string `json:"requestId" binding:"required"`
+	Correct   *bool  `json:"correct" binding:"required"`
+	Comment   string `json:"comment,omitempty" binding:"max=500"`
+}
+
+// feedbackMu serializes feedback, so that a command is only counted once
+// even when two reports for it race.
+var feedbackMu sync.Mutex
+
+// handleFeedback stores whether the command of a request did the right
+// thing next to its history entry, and counts the verdict so that prompt
+// changes can be compared over time. A request that ran several commands,
+// such as a batch, is judged by its latest one.
+func handleFeedback(c *gin.Context) {
+	var req feedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, body := bindingErrorResponse(err)
+		respond(c, status, body)
+		return
+	}
+	ctx := c.Request.Context()
+
+	feedbackMu.Lock()
+	defer feedbackMu.Unlock()
+	var entries map[string]historyEntry
+	if err := store.Get(ctx, historyPath, &entries); err != nil {
+		logFromContext(ctx).Error("failed to read command history", "error", err)
+		respond(c, errorStatus(err), gin.H{responseError: "Failed to read command history"})
+		return
+	}
+	var record *historyRecord
+	for id, entry := range entries {
+		if entry.RequestID != req.RequestID {
+			continue
+		}
+		if record == nil || entry.Timestamp > record.Timestamp || (entry.Timestamp == record.Timestamp && id > record.ID) {
+			record = &historyRecord{ID: id, historyEntry: entry}
+		}
+	}
+	switch {
+	case record == nil:
+		respond(c, http.StatusNotFound, gin.H{responseError: "No command found for request " + req.RequestID})
+		return
+	case record.ModelOutput == nil:
+		respond(c, http.StatusBadRequest, gin.H{responseError: "The command of request " + req.RequestID + " was not classified from an instruction"})
+		return
+	case record.Feedback != nil:
+		respond(c, http.StatusConflict, gin.H{responseError: "Feedback was already given for request " + req.RequestID})
+		return
+	}
+
+	fb := feedback{Correct: *req.Correct, Comment: req.Comment, Timestamp: time.Now().UnixMilli()}
+	if err := store.Set(ctx, historyPath+"/"+record.ID+"/feedback", fb); err != nil {
+		logFromContext(ctx).Error("failed to store feedback", "error", err)
+		respond(c, errorStatus(err), gin.H{responseError: "Failed to store feedback"})
+		return
+	}
+	verdict := "bad"
+	if fb.Correct {
+		verdict = "good"
+	}
+	classificationFeedback.WithLabelValues(verdict, record.ModelOutput.source()).Inc()
+	logFromContext(ctx).Info("classification feedback", "for_request_id", req.RequestID, "history_id", record.ID, "verdict", verdict)
+	record.Feedback = &fb
+	respond(c, http.StatusOK, gin.H{"message": "Feedback recorded", "entry": record})
+}
+
+// source tells what classified the command: the model, the response cache
+// or the keyword fallback.
+func (out *modelOutput) source() string {
+	switch {
+	case out.Fallback:
+		return "fallback"
+	case out.Cached:
+		return "cache"
+	default:
+		return "model"
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"go-service/iotpb"
+)
+
+// homeServer implements the gRPC API on top of the same classification,
+// validation and execution path as the HTTP handlers.
+type homeServer struct {
+	iotpb.UnimplementedHomeServer
+	cfg Config
+}
+
+func (s homeServer) ProcessInstruction(ctx context.Context, req *iotpb.InstructionRequest) (*iotpb.CommandReply, error) {
+	inst := Instruction{Instruction: req.GetInstruction(), Model: req.GetModel()}
+	ctx = withModelOutput(ctx, &modelOutput{})
+	instruction, response, code, body := classifyInstruction(ctx, s.cfg, inst)
+	if code != 0 {
+		return commandReply(code, body)
+	}
+	ctx = withOwnerPIN(withConfirmToken(ctx, req.GetConfirmToken()), req.GetPin())
+	return commandReply(recordCommand(ctx, instruction, response, req.GetDryRun()))
+}
+
+func (s homeServer) ProcessCommand(ctx context.Context, req *iotpb.CommandRequest) (*iotpb.CommandReply, error) {
+	command := AIResponse{
+		Target:    req.GetTarget(),
+		Action:    req.GetAction(),
+		Content:   req.GetContent(),
+		Location:  req.GetLocation(),
+		Locations: req.GetLocations(),
+		Color:     req.GetColor(),
+	}
+	if req.Level != nil {
+		command.Level = req.GetLevel()
+	}
+	if req.Temperature != nil {
+		command.Temperature = req.GetTemperature()
+	}
+	if req.Delay != nil {
+		command.Delay = req.GetDelay()
+	}
+	if req.Position != nil {
+		command.Position = req.GetPosition()
+	}
+	if req.Volume != nil {
+		command.Volume = req.GetVolume()
+	}
+	if c := req.GetCondition(); c != nil {
+		command.Condition = &Condition{Target: c.GetTarget(), Location: c.GetLocation(), ExpectedState: c.GetExpectedState()}
+	}
+	if err := validateAIResponse(command); err != nil {
+		return commandReply(commandErrorResponse(err))
+	}
+	logFromContext(ctx).Info("command received",
+		"target", command.Target,
+		"action", command.Action,
+		"location", command.Location,
+		"locations", command.Locations,
+	)
+
+	ctx = withOwnerPIN(withConfirmToken(ctx, req.GetConfirmToken()), req.GetPin())
+	return commandReply(recordCommand(ctx, "", command, req.GetDryRun()))
+}
+
+// commandReply converts the status and body of the HTTP API into a reply
+// holding the same Response envelope.
+// Failed commands are replies too, so that callers get the same details
+// (per-room results, confirmation tokens) as over HTTP.
+func commandReply(code int, body gin.H) (*iotpb.CommandReply, error) {
+	data, err := json.Marshal(newResponse(code, body))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode reply: %v", err)
+	}
+	var s structpb.Struct
+	if err := protojson.Unmarshal(data, &s); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode reply: %v", err)
+	}
+	return &iotpb.CommandReply{Status: int32(code), Body: &s}, nil
+}
+
+// grpcInterceptor gives every call a correlation ID and logger, checks the
+// API key sent in the authorization metadata, applies the same per-client
+// rate limit as the HTTP API and logs the outcome.
+func grpcInterceptor(keys []string, perMinute, burst int) grpc.UnaryServerInterceptor {
+	limiters := newClientLimiters(perMinute, burst)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx, id, logger := withRequestLogger(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(requestIDHeader), id))
+
+		client := "ip:" + peerAddr(ctx)
+		if len(keys) > 0 {
+			var key string
+			if values := metadata.ValueFromIncomingContext(ctx, "authorization"); len(values) > 0 {
+				key, _ = strings.CutPrefix(values[0], "Bearer ")
+			}
+			key = strings.TrimSpace(key)
+			if !validAPIKey(keys, key) {
+				return nil, status.Error(codes.Unauthenticated, "Missing or invalid API key")
+			}
+			client = "key:" + key
+		}
+		if delay, ok := limiters.allow(client, time.Now()); !ok {
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(delay.Seconds())))))
+			return nil, status.Error(codes.ResourceExhausted, "Rate limit exceeded")
+		}
+
+		resp, err := handler(ctx, req)
+		logger.Info("rpc completed",
+			"method", info.FullMethod,
+			"code", status.Code(err).String(),
+			"latency_ms", time.Since(start).Milliseconds(),
+		)
+		return resp, err
+	}
+}
+
+// peerAddr returns the IP address of the caller, without the port.
+func peerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
+
+// startGRPC serves the gRPC API on cfg.GRPCPort in the background. It
+// returns nil when the gRPC API is disabled.
+func startGRPC(cfg Config) (*grpc.Server, error) {
+	if cfg.GRPCPort == "" {
+		return nil, nil
+	}
+	lis, err := net.Listen("tcp", cfg.GRPCPort)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen on %s", cfg.GRPCPort)
+	}
+	server := grpc.NewServer(grpc.UnaryInterceptor(grpcInterceptor(cfg.APIKeys, cfg.RateLimitPerMinute, cfg.RateLimitBurst)))
+	iotpb.RegisterHomeServer(server, homeServer{cfg: cfg})
+	go func() {
+		slog.Info("gRPC server listening", "addr", cfg.GRPCPort)
+		if err := server.Serve(lis); err != nil {
+			slog.Error("gRPC server stopped", "error", err)
+		}
+	}()
+	return server, nil
+}
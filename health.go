@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const healthPath = "health"
+
+const (
+	dependencyUp   = "up"
+	dependencyDown = "down"
+)
+
+// handleHealth reports whether Firebase and the AI service are reachable.
+// Both checks run concurrently and share the configured health timeout.
+// The endpoint needs no API key, so a failing dependency is only reported
+// as down; why it failed goes to the log.
+func handleHealth(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), cfg.HealthTimeout)
+		defer cancel()
+
+		checks := map[string]func(context.Context) error{
+			"firebase":   checkFirebase,
+			"ai_service": func(ctx context.Context) error { return checkAIService(ctx, cfg) },
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		statuses := make(map[string]string, len(checks))
+		for name, check := range checks {
+			wg.Add(1)
+			go func(name string, check func(context.Context) error) {
+				defer wg.Done()
+				status := dependencyUp
+				if err := check(ctx); err != nil {
+					logFromContext(ctx).Warn("health check failed", "dependency", name, "error", err)
+					status = dependencyDown
+				}
+				mu.Lock()
+				statuses[name] = status
+				mu.Unlock()
+			}(name, check)
+		}
+		wg.Wait()
+
+		code := http.StatusOK
+		for _, status := range statuses {
+			if status != dependencyUp {
+				code = http.StatusServiceUnavailable
+			}
+		}
+		respond(c, code, gin.H{"status": http.StatusText(code), "dependencies": statuses, "ai_breaker": aiBreaker.status(), "ai_model": aiDowngrade.status()})
+	}
+}
+
+func checkFirebase(ctx context.Context) error {
+	var v interface{}
+	return errors.Wrap(store.Get(ctx, healthPath, &v), "failed to read from Firebase")
+}
+
+// checkAIService sends a HEAD request to the root of the AI service. Any
+// response below 500 means the server is up, even if it rejects the method.
+// The mock provider has no service to check.
+func checkAIService(ctx context.Context, cfg Config) error {
+	if cfg.AIProvider == providerMock {
+		return nil
+	}
+	u, err := url.Parse(cfg.AIServiceURL)
+	if err != nil {
+		return errors.Wrap(err, "invalid AI service URL")
+	}
+	u.Path, u.RawQuery = "/", ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to build AI service health request")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "AI service is unreachable")
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return &aiStatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
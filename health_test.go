@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+func TestHealthHidesDependencyErrors(t *testing.T) {
+	f := useFakeBackend(t)
+	f.fail[healthPath] = errors.New("dial tcp 10.0.0.7:443: project iot-home-secret credentials rejected")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/healthz", handleHealth(Config{AIProvider: providerMock, HealthTimeout: time.Second}))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "10.0.0.7") || strings.Contains(w.Body.String(), "iot-home-secret") {
+		t.Errorf("body %s reveals the dependency error", w.Body)
+	}
+	want := map[string]interface{}{"firebase": dependencyDown, "ai_service": dependencyUp}
+	if got := decodeResponse(t, w).Data["dependencies"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("dependencies %v, want %v", got, want)
+	}
+}
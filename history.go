@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const historyPath = "history"
+
+// historyEntry is the audit record stored for every processed command.
+type historyEntry struct {
+	RequestID string `json:"requestId"`
+	// TaskID is set on the entry of a scheduled command that has run.
+	TaskID      string        `json:"taskId,omitempty"`
+	Instruction string        `json:"instruction"`
+	Command     AIResponse    `json:"command"`
+	Writes      []deviceWrite `json:"writes"`
+	Status      int           `json:"status"`
+	Result      string        `json:"result"`
+	Timestamp   int64         `json:"timestamp"`
+	// ModelOutput is the raw answer the command was classified from, kept to
+	// correlate feedback with it. Direct commands have none.
+	ModelOutput *modelOutput `json:"modelOutput,omitempty"`
+	// Feedback is what the client reported about the command afterwards.
+	Feedback *feedback `json:"feedback,omitempty"`
+}
+
+// deviceWrite is a single path/value pair written while executing a command.
+type deviceWrite struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+	Error string      `json:"error,omitempty"`
+}
+
+// commandRecorder collects the device writes made on behalf of a command.
+// Recorders nest: a write is also added to the recorders of the enclosing
+// contexts.
+type commandRecorder struct {
+	mu     sync.Mutex
+	writes []deviceWrite
+	parent *commandRecorder
+}
+
+type recorderKey struct{}
+
+func withRecorder(ctx context.Context, rec *commandRecorder) context.Context {
+	rec.parent, _ = ctx.Value(recorderKey{}).(*commandRecorder)
+	return context.WithValue(ctx, recorderKey{}, rec)
+}
+
+// recordWrite adds a write to the recorders attached to ctx, if any.
+func recordWrite(ctx context.Context, path string, value interface{}, err error) {
+	write := deviceWrite{Path: path, Value: value}
+	if err != nil {
+		write.Error = err.Error()
+	}
+	rec, _ := ctx.Value(recorderKey{}).(*commandRecorder)
+	for ; rec != nil; rec = rec.parent {
+		rec.mu.Lock()
+		rec.writes = append(rec.writes, write)
+		rec.mu.Unlock()
+	}
+}
+
+// executeCommand runs the command and replies with its outcome. The
+// outcome is also sent to callback, or to the X-Callback-URL header when
+// callback is empty. An async request is queued and answered with 202
+// Accepted instead, its outcome reaching only the history and callback.
+func executeCommand(c *gin.Context, instruction string, response AIResponse, callback string) {
+	if callback == "" {
+		callback = c.GetHeader(callbackHeader)
+	}
+	if err := checkCallbackURL(callback); err != nil {
+		respond(c, http.StatusBadRequest, gin.H{responseError: err.Error()})
+		return
+	}
+	ctx := withCallback(withConfirmToken(c.Request.Context(), c.GetHeader(confirmHeader)), callback)
+	dryRun := dryRunRequested(c)
+	if !dryRun && asyncRequested(c) && commands.enabled() {
+		status, body := commands.enqueue(ctx, instruction, response)
+		respond(c, status, body)
+		return
+	}
+	status, body := recordCommand(ctx, instruction, response, dryRun)
+	if status >= http.StatusInternalServerError {
+		if budgetStatus, budgetBody, ok := budgetFailure(ctx, stageDevice); ok {
+			status, body = budgetStatus, budgetBody
+		}
+	}
+	respond(c, status, body)
+}
+
+// recordCommand runs processAIResponse, stores the outcome in the history
+// node and sends it to the webhook. Failing to write history never fails
+// the command itself. Dry runs are handed to dryRunCommand and leave no
+// history.
+func recordCommand(ctx context.Context, instruction string, response AIResponse, dryRun bool) (int, gin.H) {
+	response = response.withCanonicalAction()
+	if err := checkAllLocations(response); err != nil {
+		return errorResponse(err)
+	}
+	if dryRun {
+		return dryRunCommand(ctx, response)
+	}
+	rec := &commandRecorder{}
+	ctx = withRecorder(withInstruction(ctx, instruction), rec)
+	status, body := processAIResponse(ctx, response)
+
+	rec.mu.Lock()
+	writes := rec.writes
+	rec.mu.Unlock()
+	entry := saveHistory(ctx, instruction, response, writes, status)
+	webhooks.notify(ctx, webhookPayload{
+		RequestID:   entry.RequestID,
+		Instruction: instruction,
+		Command:     response,
+		Status:      status,
+		Result:      newResponse(status, body),
+	})
+	return status, body
+}
+
+// saveHistory stores the outcome of a command in the history node and
+// returns the entry. Failing to write it is only logged.
+func saveHistory(ctx context.Context, instruction string, response AIResponse, writes []deviceWrite, status int) historyEntry {
+	result := "success"
+	if status >= http.StatusBadRequest {
+		result = "error"
+	}
+	entry := historyEntry{
+		RequestID:   requestIDFromContext(ctx),
+		TaskID:      taskIDFromContext(ctx),
+		Instruction: instruction,
+		Command:     response,
+		Writes:      writes,
+		Status:      status,
+		Result:      result,
+		Timestamp:   time.Now().UnixMilli(),
+		ModelOutput: modelOutputFromContext(ctx),
+	}
+	// The outcome is recorded even when the client has gone away and
+	// cancelled the command part way through.
+	if err := store.Push(context.WithoutCancel(ctx), historyPath, entry); err != nil {
+		logFromContext(ctx).Error("failed to write command history", "error", err)
+	}
+	return entry
+}
+
+type instructionKey struct{}
+
+// withInstruction returns ctx carrying the instruction a command was
+// classified from, so that a scheduled command records it when it runs.
+func withInstruction(ctx context.Context, instruction string) context.Context {
+	return context.WithValue(ctx, instructionKey{}, instruction)
+}
+
+func instructionFromContext(ctx context.Context) string {
+	instruction, _ := ctx.Value(instructionKey{}).(string)
+	return instruction
+}
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 1000
+)
+
+// historyRecord is a stored history entry together with its key.
+type historyRecord struct {
+	ID string `json:"id"`
+	historyEntry
+}
+
+// historyFilter selects history entries; zero fields match everything.
+type historyFilter struct {
+	Target   string
+	Action   string
+	Location string
+	From, To time.Time
+}
+
+func (f historyFilter) matches(e historyEntry) bool {
+	ts := time.UnixMilli(e.Timestamp)
+	switch {
+	case f.Target != "" && !strings.EqualFold(e.Command.Target, f.Target):
+		return false
+	case f.Action != "" && !strings.EqualFold(e.Command.Action, f.Action):
+		return false
+	case !f.From.IsZero() && ts.Before(f.From):
+		return false
+	case !f.To.IsZero() && ts.After(f.To):
+		return false
+	}
+	if f.Location == "" {
+		return true
+	}
+	for _, location := range e.Command.targetLocations() {
+		if normalizeLocation(location) == f.Location {
+			return true
+		}
+	}
+	return false
+}
+
+// handleHistory returns the most recent history entries matching the
+// target, action, location, from and to query parameters, newest first.
+// Times are RFC 3339 or Unix milliseconds; limit defaults to 50.
+//
+// The history node is read as a whole and filtered here, which keeps the
+// database free of index rules at the size a single home produces.
+func handleHistory(c *gin.Context) {
+	filter := historyFilter{
+		Target: c.Query("target"),
+		Action: c.Query("action"),
+	}
+	if location := c.Query("location"); location != "" {
+		filter.Location = normalizeLocation(location)
+	}
+	var err error
+	if filter.From, err = parseHistoryTime(c.Query("from")); err != nil {
+		respond(c, http.StatusBadRequest, gin.H{responseError: "Invalid from: " + err.Error()})
+		return
+	}
+	if filter.To, err = parseHistoryTime(c.Query("to")); err != nil {
+		respond(c, http.StatusBadRequest, gin.H{responseError: "Invalid to: " + err.Error()})
+		return
+	}
+	limit := defaultHistoryLimit
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxHistoryLimit {
+			respond(c, http.StatusBadRequest, gin.H{responseError: fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit)})
+			return
+		}
+	}
+
+	var entries map[string]historyEntry
+	if err := store.Get(c.Request.Context(), historyPath, &entries); err != nil {
+		logFromContext(c.Request.Context()).Error("failed to read command history", "error", err)
+		respond(c, errorStatus(err), gin.H{responseError: "Failed to read command history"})
+		return
+	}
+	records := make([]historyRecord, 0)
+	for id, entry := range entries {
+		if filter.matches(entry) {
+			records = append(records, historyRecord{ID: id, historyEntry: entry})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Timestamp != records[j].Timestamp {
+			return records[i].Timestamp > records[j].Timestamp
+		}
+		return records[i].ID > records[j].ID
+	})
+	total := len(records)
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	respond(c, http.StatusOK, gin.H{"entries": records, "count": len(records), "total": total})
+}
+
+// parseHistoryTime parses an RFC 3339 time or Unix milliseconds. An empty
+// value is the zero time.
+func parseHistoryTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errors.New("expected an RFC 3339 time or Unix milliseconds")
+	}
+	return t, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyHeader   = "Idempotency-Key"
+	idempotencyReplayed = "Idempotent-Replayed"
+)
+
+// idempotencyStore remembers the replies sent for idempotency keys so that
+// a retried request is answered without running the command again.
+type idempotencyStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*idempotentReply
+}
+
+type idempotentReply struct {
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// capturingWriter keeps a copy of the response body.
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// idempotency replays the stored reply of a request whose Idempotency-Key
+// was already used by the same client within ttl. Keys are scoped per
+// client, so two clients cannot see each other's replies. Server errors
+// are not stored, and neither is a request that panicked, letting a retry
+// run the command again. Neither are 409 replies: the retry of a command
+// that asked for confirmation carries its token under the same key.
+func idempotency(ttl time.Duration) gin.HandlerFunc {
+	store := &idempotencyStore{ttl: ttl, entries: make(map[string]*idempotentReply)}
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		key = clientKey(c) + "|" + c.FullPath() + "|" + key
+
+		reply, fresh := store.begin(key)
+		if !fresh {
+			if !reply.done {
+				abortWithResponse(c, http.StatusConflict, gin.H{responseError: "A request with this Idempotency-Key is still in progress"})
+				return
+			}
+			logFromContext(c.Request.Context()).Info("idempotent reply replayed", "status", reply.status)
+			c.Header(idempotencyReplayed, "true")
+			c.Data(reply.status, reply.contentType, reply.body)
+			c.Abort()
+			return
+		}
+
+		w := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		completed := false
+		defer func() {
+			if !completed {
+				store.release(key)
+			}
+		}()
+		c.Next()
+		completed = true
+		store.finish(key, w.Status(), w.Header().Get("Content-Type"), w.body.Bytes())
+	}
+}
+
+// begin returns the reply stored for key, or reserves key and reports
+// fresh when the request has not been seen.
+func (s *idempotencyStore) begin(key string) (reply idempotentReply, fresh bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, r := range s.entries {
+		if r.done && now.After(r.expires) {
+			delete(s.entries, k)
+		}
+	}
+	if r, ok := s.entries[key]; ok {
+		return *r, false
+	}
+	s.entries[key] = &idempotentReply{}
+	return idempotentReply{}, true
+}
+
+func (s *idempotencyStore) finish(key string, status int, contentType string, body []byte) {
+	if status >= http.StatusInternalServerError || status == http.StatusConflict {
+		s.release(key)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &idempotentReply{
+		done:        true,
+		status:      status,
+		contentType: contentType,
+		body:        bytes.Clone(body),
+		expires:     time.Now().Add(s.ttl),
+	}
+}
+
+// release drops the reservation of key, for requests whose reply is not
+// worth replaying.
+func (s *idempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newIdempotentRouter(handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.POST("/api/command", idempotency(time.Minute), handler)
+	return r
+}
+
+func postWithKey(r http.Handler, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/command", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set(idempotencyHeader, key)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyReplays(t *testing.T) {
+	calls := 0
+	r := newIdempotentRouter(func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusOK, gin.H{"calls": calls})
+	})
+	first := postWithKey(r, "abc")
+	second := postWithKey(r, "abc")
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+	if second.Code != first.Code || second.Body.String() != first.Body.String() || second.Header().Get(idempotencyReplayed) != "true" {
+		t.Fatalf("replay %d %q, want %d %q", second.Code, second.Body, first.Code, first.Body)
+	}
+	if postWithKey(r, "other"); calls != 2 {
+		t.Fatalf("another key did not run the handler")
+	}
+}
+
+func TestIdempotencyServerErrorIsRetried(t *testing.T) {
+	calls := 0
+	r := newIdempotentRouter(func(c *gin.Context) {
+		calls++
+		c.Status(http.StatusServiceUnavailable)
+	})
+	postWithKey(r, "abc")
+	postWithKey(r, "abc")
+	if calls != 2 {
+		t.Fatalf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestIdempotencyConfirmationIsRetried(t *testing.T) {
+	r := newIdempotentRouter(func(c *gin.Context) {
+		if c.GetHeader(confirmHeader) == "" {
+			c.JSON(http.StatusConflict, gin.H{"confirm_token": "t"})
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	if w := postWithKey(r, "abc"); w.Code != http.StatusConflict {
+		t.Fatalf("first request: status %d, want 409", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/command", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set(idempotencyHeader, "abc")
+	req.Header.Set(confirmHeader, "t")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get(idempotencyReplayed) != "" {
+		t.Fatalf("confirmed retry: status %d, replayed %q, want the command run", w.Code, w.Header().Get(idempotencyReplayed))
+	}
+}
+
+func TestIdempotencyPanicReleasesKey(t *testing.T) {
+	calls := 0
+	r := newIdempotentRouter(func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		c.Status(http.StatusOK)
+	})
+	if w := postWithKey(r, "abc"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("first request: status %d, want 500", w.Code)
+	}
+	if w := postWithKey(r, "abc"); w.Code != http.StatusOK {
+		t.Fatalf("retry: status %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,5 @@
+// Package iotpb contains the gRPC API of the service, generated from
+// iot.proto.
+package iotpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative iot.proto
@@ -0,0 +1,171 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: iot.proto
+
+package iotpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Home_ProcessInstruction_FullMethodName = "/iot.v1.Home/ProcessInstruction"
+	Home_ProcessCommand_FullMethodName     = "/iot.v1.Home/ProcessCommand"
+)
+
+// HomeClient is the client API for Home service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Home mirrors the command endpoints of the HTTP API.
+type HomeClient interface {
+	// ProcessInstruction classifies a natural-language instruction and runs
+	// the resulting command, like POST /api.
+	ProcessInstruction(ctx context.Context, in *InstructionRequest, opts ...grpc.CallOption) (*CommandReply, error)
+	// ProcessCommand runs an already structured command, like POST
+	// /api/command.
+	ProcessCommand(ctx context.Context, in *CommandRequest, opts ...grpc.CallOption) (*CommandReply, error)
+}
+
+type homeClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHomeClient(cc grpc.ClientConnInterface) HomeClient {
+	return &homeClient{cc}
+}
+
+func (c *homeClient) ProcessInstruction(ctx context.Context, in *InstructionRequest, opts ...grpc.CallOption) (*CommandReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CommandReply)
+	err := c.cc.Invoke(ctx, Home_ProcessInstruction_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *homeClient) ProcessCommand(ctx context.Context, in *CommandRequest, opts ...grpc.CallOption) (*CommandReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CommandReply)
+	err := c.cc.Invoke(ctx, Home_ProcessCommand_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HomeServer is the server API for Home service.
+// All implementations must embed UnimplementedHomeServer
+// for forward compatibility.
+//
+// Home mirrors the command endpoints of the HTTP API.
+type HomeServer interface {
+	// ProcessInstruction classifies a natural-language instruction and runs
+	// the resulting command, like POST /api.
+	ProcessInstruction(context.Context, *InstructionRequest) (*CommandReply, error)
+	// ProcessCommand runs an already structured command, like POST
+	// /api/command.
+	ProcessCommand(context.Context, *CommandRequest) (*CommandReply, error)
+	mustEmbedUnimplementedHomeServer()
+}
+
+// UnimplementedHomeServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedHomeServer struct{}
+
+func (UnimplementedHomeServer) ProcessInstruction(context.Context, *InstructionRequest) (*CommandReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ProcessInstruction not implemented")
+}
+func (UnimplementedHomeServer) ProcessCommand(context.Context, *CommandRequest) (*CommandReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ProcessCommand not implemented")
+}
+func (UnimplementedHomeServer) mustEmbedUnimplementedHomeServer() {}
+func (UnimplementedHomeServer) testEmbeddedByValue()              {}
+
+// UnsafeHomeServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HomeServer will
+// result in compilation errors.
+type UnsafeHomeServer interface {
+	mustEmbedUnimplementedHomeServer()
+}
+
+func RegisterHomeServer(s grpc.ServiceRegistrar, srv HomeServer) {
+	// If the following call pancis, it indicates UnimplementedHomeServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Home_ServiceDesc, srv)
+}
+
+func _Home_ProcessInstruction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InstructionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HomeServer).ProcessInstruction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Home_ProcessInstruction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HomeServer).ProcessInstruction(ctx, req.(*InstructionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Home_ProcessCommand_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommandRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HomeServer).ProcessCommand(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Home_ProcessCommand_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HomeServer).ProcessCommand(ctx, req.(*CommandRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Home_ServiceDesc is the grpc.ServiceDesc for Home service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Home_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "iot.v1.Home",
+	HandlerType: (*HomeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ProcessInstruction",
+			Handler:    _Home_ProcessInstruction_Handler,
+		},
+		{
+			MethodName: "ProcessCommand",
+			Handler:    _Home_ProcessCommand_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "iot.proto",
+}
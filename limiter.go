@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/semaphore"
+)
+
+// ErrAIBusy is returned when the AI service already has as many requests in
+// flight and waiting as allowed.
+var ErrAIBusy = errors.New("Too many concurrent AI requests, please retry")
+
+// aiLimiter bounds the classifications sent to the AI service at once. A
+// model server runs few inferences in parallel, so extra requests wait in a
+// bounded queue instead of piling up latency; beyond it they are refused.
+// A nil *aiLimiter does not limit anything.
+type aiLimiter struct {
+	sem      *semaphore.Weighted
+	maxQueue int64
+	waiting  atomic.Int64
+}
+
+// aiLimit is the active limiter, set up in main.
+var aiLimit *aiLimiter
+
+func newAILimiter(maxConcurrent, maxQueue int) *aiLimiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	return &aiLimiter{sem: semaphore.NewWeighted(int64(maxConcurrent)), maxQueue: int64(maxQueue)}
+}
+
+// acquire waits for a free slot, or fails with ErrAIBusy when the queue is
+// full. The returned function releases the slot.
+func (l *aiLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if !l.sem.TryAcquire(1) {
+		if l.waiting.Add(1) > l.maxQueue {
+			l.waiting.Add(-1)
+			return nil, ErrAIBusy
+		}
+		aiRequestsQueued.Inc()
+		err := l.sem.Acquire(ctx, 1)
+		l.waiting.Add(-1)
+		aiRequestsQueued.Dec()
+		if err != nil {
+			return nil, errors.Wrap(err, "gave up waiting for an AI request slot")
+		}
+	}
+	aiRequestsInFlight.Inc()
+	return func() {
+		aiRequestsInFlight.Dec()
+		l.sem.Release(1)
+	}, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	langEnglish    = "en"
+	langVietnamese = "vi"
+)
+
+// localizedMessage translates an English message matching pattern. The
+// submatches fill the placeholders of the translation, each one translated
+// word by word first.
+type localizedMessage struct {
+	pattern     *regexp.Regexp
+	translation string
+}
+
+// messageCatalog holds the translations of the human-readable messages and
+// errors, by language. Messages without an entry stay in English, as do
+// the structured fields of every response.
+var messageCatalog = map[string][]localizedMessage{
+	langVietnamese: {
+		message(`(Light|Fan) (on|off|open|close) in (.+)`, "Đã %[2]s %[1]s ở %[3]s"),
+		message(`Door (open|close|on|off|lock|unlock) in (.+)`, "Đã %[1]s cửa %[2]s"),
+		message(`Toggled (\w+) in (.+)`, "Đã chuyển trạng thái %s ở %s"),
+		message(`Light level set to (\d+) in (.+)`, "Đã đặt độ sáng đèn %s%% ở %s"),
+		message(`Light level (increase|decrease)d by (\d+) in (.+)`, "Đã %s độ sáng đèn %s%% ở %s"),
+		message(`Light color set to (#[0-9A-F]{6}) in (.+)`, "Đã đổi màu đèn sang %s ở %s"),
+		message(`Blind position set to (\d+)% in (.+)`, "Đã mở rèm %s%% ở %s"),
+		message(`Air conditioner set to (\d+)°C`, "Đã đặt điều hòa ở %s°C"),
+		message(`Air conditioner (on|off)`, "Đã %s điều hòa"),
+		message(`Playing (.+) on (\w+)`, "Đang phát %s trên %s"),
+		message(`Stopped (\w+)`, "Đã dừng %s"),
+		message(`Speaker volume set to (\d+)`, "Đã đặt âm lượng loa ở mức %s"),
+		message(`Speaker (mute|unmute)d`, "Đã %s loa"),
+		message(`Scene (.+) done`, "Đã chạy cảnh %s"),
+		message(`Scheduled (\w+) (\w+) in (.+)`, "Đã hẹn %[2]s %[1]s sau %[3]s"),
+		message(`Queued (\w+) (\w+)`, "Đã xếp hàng lệnh %[2]s %[1]s"),
+		message(`Condition not met, no action taken`, "Điều kiện không thỏa, không thực hiện lệnh"),
+		message(`Feedback recorded`, "Đã ghi nhận phản hồi"),
+		message(`House status`, "Trạng thái ngôi nhà"),
+		message(`The service is not allowed to access the database; check its service account and database rules`, "Dịch vụ không có quyền truy cập cơ sở dữ liệu; hãy kiểm tra tài khoản dịch vụ và quy tắc bảo mật"),
+		message(`The (\w+) in every room`, "%s ở mọi phòng"),
+		message(`The (\w+) in (.+) is (.+)`, "%s ở %s là %s"),
+		message(`The (\w+) is (.+)`, "%s là %s"),
+		message(`(\d+) of (\d+) instructions succeeded`, "%s/%s lệnh thành công"),
+		message(`(\d+) of (\d+) locations failed`, "%s/%s vị trí thất bại"),
+		message(`(\d+) of (\d+) steps failed`, "%s/%s bước thất bại"),
+		message(`AI model "(.+)" is not available on the AI service: (.+)`, "Dịch vụ AI không có mô hình %q: %s"),
+		message(`Request ran out of its (.+) time budget during (.+)`, "Yêu cầu đã hết thời gian %s trong bước %s"),
+		message(`Invalid request payload`, "Yêu cầu không hợp lệ"),
+		message(`Invalid location "(.+)" for (\w+), did you mean "(.+)"\?`, "Vị trí %q không có %s, có phải ý bạn là %q?"),
+		message(`Invalid location "(.+)" for (\w+)`, "Vị trí %q không có %s"),
+		message(`Invalid location`, "Vị trí không hợp lệ"),
+		message(`You are not the owner`, "Bạn không phải là chủ nhà"),
+		message(`Rate limit exceeded`, "Quá nhiều yêu cầu, vui lòng thử lại sau"),
+		message(`Missing or invalid API key`, "Thiếu hoặc sai khóa API"),
+		message(`request body too large`, "Nội dung yêu cầu quá lớn"),
+		message(`not found`, "Không tìm thấy"),
+		message(`method not allowed`, "Phương thức không được hỗ trợ"),
+	},
+}
+
+// messageWords translates the words that appear inside messages: targets,
+// actions and rooms.
+var messageWords = map[string]map[string]string{
+	langVietnamese: {
+		"light": "đèn", "fan": "quạt", "door": "cửa", "music": "nhạc", "tv": "TV",
+		"on": "bật", "off": "tắt", "open": "mở", "close": "đóng",
+		"lock": "khóa", "unlock": "mở khóa",
+		"increase": "tăng", "decrease": "giảm", "mute": "tắt tiếng", "unmute": "bật tiếng",
+		"temperature": "nhiệt độ", "humidity": "độ ẩm",
+		"living room": "phòng khách", "bedroom": "phòng ngủ", "kitchen": "nhà bếp",
+		"toilet": "nhà vệ sinh", "wc": "nhà vệ sinh", "all": "mọi phòng",
+		"front": "trước", "back": "sau",
+	},
+}
+
+// message compiles a catalog entry; pattern must match a whole message.
+func message(pattern, translation string) localizedMessage {
+	return localizedMessage{pattern: regexp.MustCompile(`^` + pattern + `$`), translation: translation}
+}
+
+// requestLanguage picks the message language from the lang query parameter
+// or the Accept-Language header, in the order the client prefers, falling
+// back to English.
+func requestLanguage(c *gin.Context) string {
+	candidates := []string{c.Query("lang")}
+	for _, tag := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		tag, _, _ = strings.Cut(tag, ";")
+		candidates = append(candidates, tag)
+	}
+	for _, tag := range candidates {
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if primary == langEnglish {
+			return langEnglish
+		}
+		if _, ok := messageCatalog[primary]; ok {
+			return primary
+		}
+	}
+	return langEnglish
+}
+
+// localize translates text into lang, or returns it unchanged when the
+// catalog has no entry for it.
+func localize(lang, text string) string {
+	for _, entry := range messageCatalog[lang] {
+		groups := entry.pattern.FindStringSubmatch(text)
+		if groups == nil {
+			continue
+		}
+		args := make([]interface{}, 0, len(groups)-1)
+		for _, group := range groups[1:] {
+			args = append(args, localizeWords(lang, group))
+		}
+		return fmt.Sprintf(entry.translation, args...)
+	}
+	return text
+}
+
+// localizeWords translates a word or a comma-separated list of words,
+// keeping the ones it does not know.
+func localizeWords(lang, text string) string {
+	items := strings.Split(text, ", ")
+	for i, item := range items {
+		if word, ok := messageWords[lang][strings.ToLower(item)]; ok {
+			items[i] = word
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+// localizeResponse translates the message and error of resp into lang.
+func localizeResponse(resp Response, lang string) Response {
+	if lang == langEnglish {
+		return resp
+	}
+	resp.Message = localize(lang, resp.Message)
+	resp.Error = localize(lang, resp.Error)
+	return resp
+}
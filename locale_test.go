@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLocalizeVietnamese(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Light on in living room", "Đã bật đèn ở phòng khách"},
+		{"Fan off in kitchen, bedroom", "Đã tắt quạt ở nhà bếp, phòng ngủ"},
+		{"Door unlock in front", "Đã mở khóa cửa trước"},
+		{"Light level set to 40 in bedroom", "Đã đặt độ sáng đèn 40% ở phòng ngủ"},
+		{"Condition not met, no action taken", "Điều kiện không thỏa, không thực hiện lệnh"},
+		{`Invalid location "garage" for light, did you mean "garden"?`, `Vị trí "garage" không có đèn, có phải ý bạn là "garden"?`},
+		{`Invalid location "garage" for light`, `Vị trí "garage" không có đèn`},
+		{"The light in kitchen is on", "đèn ở nhà bếp là bật"},
+		{"not found", "Không tìm thấy"},
+		{"Something the catalog does not know", "Something the catalog does not know"},
+	}
+	for _, tt := range tests {
+		if got := localize(langVietnamese, tt.text); got != tt.want {
+			t.Errorf("localize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRequestLanguage(t *testing.T) {
+	tests := []struct {
+		query  string
+		header string
+		want   string
+	}{
+		{want: langEnglish},
+		{header: "vi-VN,vi;q=0.9,en;q=0.8", want: langVietnamese},
+		{header: "fr-FR, vi;q=0.5", want: langVietnamese},
+		{header: "en-US, vi;q=0.5", want: langEnglish},
+		{header: "de", want: langEnglish},
+		{query: "vi", header: "en", want: langVietnamese},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/status?lang="+tt.query, nil)
+		if tt.header != "" {
+			req.Header.Set("Accept-Language", tt.header)
+		}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		if got := requestLanguage(c); got != tt.want {
+			t.Errorf("requestLanguage(lang=%q, Accept-Language %q) = %q, want %q", tt.query, tt.header, got, tt.want)
+		}
+	}
+}
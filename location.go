@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// locationArticles are leading words dropped from a location, so that
+// "the kitchen" and "my bedroom" name the same rooms as "kitchen" and
+// "bedroom".
+var locationArticles = []string{"the", "a", "an", "my", "our"}
+
+// locationSynonyms maps alternative room names, as they appear after
+// normalization, to the room name used by the device registry. Add entries
+// here to teach the service new ways of naming a room.
+var locationSynonyms = map[string]string{
+	"bath":           "toilet",
+	"bathroom":       "toilet",
+	"restroom":       "toilet",
+	"washroom":       "toilet",
+	"lavatory":       "toilet",
+	"lounge":         "living room",
+	"living":         "living room",
+	"livingroom":     "living room",
+	"sitting room":   "living room",
+	"family room":    "living room",
+	"bed room":       "bedroom",
+	"master bedroom": "bedroom",
+	"kitchenette":    "kitchen",
+	"everywhere":     "all",
+	"every room":     "all",
+	"all rooms":      "all",
+	"whole house":    "all",
+
+	// Vietnamese, with and without diacritics.
+	"phòng khách": "living room",
+	"phong khach": "living room",
+	"phòng ngủ":   "bedroom",
+	"phong ngu":   "bedroom",
+	"nhà bếp":     "kitchen",
+	"nha bep":     "kitchen",
+	"phòng bếp":   "kitchen",
+	"phong bep":   "kitchen",
+	"bếp":         "kitchen",
+	"bep":         "kitchen",
+	"nhà vệ sinh": "toilet",
+	"nha ve sinh": "toilet",
+	"phòng tắm":   "toilet",
+	"phong tam":   "toilet",
+	"cửa trước":   "front",
+	"cua truoc":   "front",
+	"cửa chính":   "front",
+	"cua chinh":   "front",
+	"cửa sau":     "back",
+	"cua sau":     "back",
+	"tất cả":      "all",
+	"tat ca":      "all",
+	"cả nhà":      "all",
+	"ca nha":      "all",
+	"toàn bộ nhà": "all",
+	"toan bo nha": "all",
+}
+
+// normalizeLocation lowercases location, collapses its whitespace, strips
+// leading articles and resolves synonyms.
+func normalizeLocation(location string) string {
+	words := strings.Fields(strings.ToLower(location))
+	for len(words) > 1 && isArticle(words[0]) {
+		words = words[1:]
+	}
+	normalized := strings.Join(words, " ")
+	if room, ok := locationSynonyms[normalized]; ok {
+		return room
+	}
+	return normalized
+}
+
+func isArticle(word string) bool {
+	for _, article := range locationArticles {
+		if word == article {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestDistance is the largest edit distance at which an unknown location
+// is answered with the nearest known one as a suggestion.
+const suggestDistance = 3
+
+// locationAutocorrect is the largest edit distance at which an unknown
+// location is taken to mean the nearest known one, set up in main. Zero
+// turns the correction off and only suggests.
+var locationAutocorrect int
+
+// locationError reports a location where target has no device, with the
+// locations it does have and the nearest of them when it is close enough.
+type locationError struct {
+	Target     string
+	Location   string
+	Known      []string
+	Suggestion string
+}
+
+func (e *locationError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("Invalid location %q for %s, did you mean %q?", e.Location, e.Target, e.Suggestion)
+	}
+	return fmt.Sprintf("Invalid location %q for %s", e.Location, e.Target)
+}
+
+func (e *locationError) Unwrap() error { return ErrInvalidLocation }
+
+// correctLocation returns the room of rooms an unknown, normalized location
+// stands for when it is within locationAutocorrect edits of it, and a
+// *locationError otherwise. A location is never corrected or suggested by
+// more than a third of its length, so short names do not match anything.
+func correctLocation(target, location string, rooms map[string]string) (string, error) {
+	known := make([]string, 0, len(rooms)+1)
+	for room := range rooms {
+		known = append(known, room)
+	}
+	sort.Strings(known)
+
+	nearest, distance := "", -1
+	for _, room := range known {
+		if d := levenshtein(location, room); distance < 0 || d < distance {
+			nearest, distance = room, d
+		}
+	}
+	limit := utf8.RuneCountInString(location) / 3
+	if nearest != "" && distance <= min(locationAutocorrect, limit) {
+		slog.Info("location corrected", "target", target, "location", location, "corrected", nearest, "distance", distance)
+		return nearest, nil
+	}
+	err := &locationError{Target: target, Location: location, Known: append(known, "all")}
+	if nearest != "" && distance <= min(suggestDistance, limit) {
+		err.Suggestion = nearest
+	}
+	return "", err
+}
+
+// levenshtein returns the number of single-rune insertions, deletions and
+// substitutions turning a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestNormalizeLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"kitchen", "kitchen"},
+		{"Kitchen", "kitchen"},
+		{"  LIVING   Room ", "living room"},
+		{"the kitchen", "kitchen"},
+		{"The Living Room", "living room"},
+		{"my bedroom", "bedroom"},
+		{"our bath", "toilet"},
+		{"restroom", "toilet"},
+		{"Bathroom", "toilet"},
+		{"the lounge", "living room"},
+		{"Whole House", "all"},
+		{"the", "the"},
+		{"garage", "garage"},
+	}
+	for _, tt := range tests {
+		if got := normalizeLocation(tt.location); got != tt.want {
+			t.Errorf("normalizeLocation(%q) = %q, want %q", tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeVietnameseLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"phòng khách", "living room"},
+		{"Phòng Khách", "living room"},
+		{"phong khach", "living room"},
+		{"nhà bếp", "kitchen"},
+		{"  Nhà   Bếp ", "kitchen"},
+		{"phòng ngủ", "bedroom"},
+		{"nhà vệ sinh", "toilet"},
+		{"cửa trước", "front"},
+		{"cả nhà", "all"},
+	}
+	for _, tt := range tests {
+		if got := normalizeLocation(tt.location); got != tt.want {
+			t.Errorf("normalizeLocation(%q) = %q, want %q", tt.location, got, tt.want)
+		}
+	}
+}
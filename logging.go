@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// logLevel is the minimum level of the default logger.
+var logLevel slog.LevelVar
+
+type (
+	loggerKey    struct{}
+	requestIDKey struct{}
+)
+
+// requestLogger assigns every request a correlation ID, returns it in the
+// X-Request-ID header and attaches a logger carrying it to the request
+// context so that downstream log lines can be correlated.
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		ctx, id, logger := withRequestLogger(c.Request.Context())
+		c.Header(requestIDHeader, id)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		logger.Info("request completed",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency_ms", time.Since(start).Milliseconds(),
+		)
+	}
+}
+
+// withRequestLogger assigns a new correlation ID to ctx and attaches a
+// logger carrying it, and the trace ID when ctx is traced.
+func withRequestLogger(ctx context.Context) (context.Context, string, *slog.Logger) {
+	id := uuid.NewString()
+	logger := slog.Default().With("request_id", id)
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		logger = logger.With("trace_id", sc.TraceID().String())
+	}
+	ctx = context.WithValue(ctx, loggerKey{}, logger)
+	return context.WithValue(ctx, requestIDKey{}, id), id, logger
+}
+
+// logFromContext returns the request-scoped logger, or the default logger
+// when ctx does not belong to a request.
+func logFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// requestIDFromContext returns the correlation ID of the request, if any.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// fatal logs err and terminates the process.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
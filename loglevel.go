@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logLevelMu makes the read-modify-write of a toggle atomic; logLevel
+// itself is safe to read from any goroutine.
+var logLevelMu sync.Mutex
+
+// setLogLevel changes the level of the default logger and returns the one
+// it replaces.
+func setLogLevel(level slog.Level) slog.Level {
+	logLevelMu.Lock()
+	defer logLevelMu.Unlock()
+	previous := logLevel.Level()
+	logLevel.Set(level)
+	return previous
+}
+
+// toggleLogLevel switches between info and debug and returns the new level.
+func toggleLogLevel() slog.Level {
+	logLevelMu.Lock()
+	defer logLevelMu.Unlock()
+	level := slog.LevelDebug
+	if logLevel.Level() <= slog.LevelDebug {
+		level = slog.LevelInfo
+	}
+	logLevel.Set(level)
+	return level
+}
+
+// debugLogging reports whether debug logs are currently written.
+func debugLogging() bool {
+	return logLevel.Level() <= slog.LevelDebug
+}
+
+// watchLogLevel toggles the log level whenever the process receives
+// SIGUSR1, so that a live issue can be debugged without a restart.
+func watchLogLevel() {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			level := toggleLogLevel()
+			slog.Warn("log level changed", "level", level.String(), "source", "SIGUSR1")
+		}
+	}()
+}
+
+// handleGetLogLevel reports the current log level.
+func handleGetLogLevel(c *gin.Context) {
+	respond(c, http.StatusOK, gin.H{"level": logLevel.Level().String()})
+}
+
+// handleSetLogLevel sets the log level from a body such as
+// {"level": "debug"}.
+func handleSetLogLevel(c *gin.Context) {
+	var req struct {
+		Level string `json:"level" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, body := bindingErrorResponse(err)
+		respond(c, status, body)
+		return
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		respond(c, http.StatusBadRequest, gin.H{responseError: "Invalid log level, expected debug, info, warn or error"})
+		return
+	}
+	previous := setLogLevel(level)
+	logFromContext(c.Request.Context()).Warn("log level changed", "level", level.String(), "previous", previous.String(), "source", "api")
+	respond(c, http.StatusOK, gin.H{"level": level.String(), "previous": previous.String()})
+}
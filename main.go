@@ -1,22 +1,43 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/db"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
-	"golang.org/x/net/context"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"google.golang.org/api/option"
 )
 
 type Instruction struct {
-	Instruction string `json:"instruction"`
+	Instruction string `json:"instruction" binding:"required"`
+	// Model optionally overrides the configured AI model. It must be one
+	// of the allowed models.
+	Model string `json:"model,omitempty"`
+	// CallbackURL optionally receives the result once the command has
+	// completed, instead of the global webhook.
+	CallbackURL string `json:"callbackUrl,omitempty" binding:"omitempty,url"`
+	// PIN optionally authorizes door commands as the owner, see OWNER_MODE.
+	PIN string `json:"pin,omitempty"`
 }
 
 type AIResponse struct {
@@ -24,192 +45,999 @@ type AIResponse struct {
 	Action   string `json:"action"`
 	Content  string `json:"content"`
 	Location string `json:"location"`
+	// Locations lists every room of a compound command such as "turn on
+	// the living room and kitchen lights". It takes precedence over
+	// Location when present.
+	Locations []string `json:"locations,omitempty"`
+	// Level is the optional brightness (0-100) for dimmable lights. It is
+	// kept untyped so a non-numeric value from the model can be reported
+	// as a bad request instead of failing the whole parse.
+	Level interface{} `json:"level,omitempty"`
+	// Temperature is the optional air conditioner setpoint in °C.
+	Temperature interface{} `json:"temperature,omitempty"`
+	// Position is the optional blind opening (0-100, 100 fully open).
+	Position interface{} `json:"position,omitempty"`
+	// Color is the optional color of an RGB light, a name such as "blue"
+	// or a "#RRGGBB" string.
+	Color string `json:"color,omitempty"`
+	// Volume is the optional speaker volume (0-100).
+	Volume interface{} `json:"volume,omitempty"`
+	// Delay is the optional number of seconds to wait before acting.
+	Delay interface{} `json:"delay,omitempty"`
+	// Condition, when set, is checked right before acting; the command is
+	// skipped unless it holds.
+	Condition *Condition `json:"condition,omitempty"`
 }
 
-var client *db.Client
+// httpClient is shared by all calls to the AI service; its timeout and
+// transport are set from the configuration at startup.
+var httpClient = &http.Client{Timeout: defaultAITimeout}
+
+// newAITransport returns the transport used for the AI service. Requests
+// all go to the same one or two hosts, so keeping more idle connections
+// per host than the default of 2 avoids reconnecting under load.
+func newAITransport(cfg Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = cfg.AIMaxIdleConns
+	t.MaxIdleConnsPerHost = cfg.AIMaxIdleConnsPerHost
+	t.IdleConnTimeout = cfg.AIIdleConnTimeout
+	return t
+}
 
 const (
-	lightPrefix   = "light"
-	doorPath      = "door/turn"
-	databaseURL   = "https://iot-grio9-52213-default-rtdb.asia-southeast1.firebasedatabase.app/"
-	aiServiceURL  = "http://localhost:11434/api/generate"
-	serviceKey    = "./serviceAccountKey.json"
+	acTurnPath    = "ac/turn"
+	acTempPath    = "ac/temp"
+	minACTemp     = 16
+	maxACTemp     = 30
+	turnField     = "turn"
+	levelField    = "level"
+	lockField     = "lock"
 	actionOn      = "1"
 	actionOff     = "0"
-	port          = ":3000"
 	responseError = "error"
 )
 
-func initFirebase() error {
+func initFirebase(cfg Config) (*db.Client, error) {
 	ctx := context.Background()
-	conf := option.WithCredentialsFile(serviceKey)
+	var opts []option.ClientOption
+	databaseURL := cfg.DatabaseURL
+	if cfg.emulated() {
+		// The Admin SDK cannot tell the namespace from a bare
+		// FIREBASE_DATABASE_EMULATOR_HOST, so hand it an emulator URL in
+		// the host:port?ns=name form it expects. It then authenticates
+		// with the emulator's own token, so no credentials are passed.
+		databaseURL = cfg.EmulatorHost + "?ns=" + url.QueryEscape(cfg.emulatorNamespace())
+		slog.Info("using the Realtime Database emulator", "host", cfg.EmulatorHost)
+	} else {
+		if err := checkCredentials(cfg.CredFile); err != nil {
+			return nil, err
+		}
+		opts = append(opts, option.WithCredentialsFile(cfg.CredFile))
+	}
 
-	app, err := firebase.NewApp(ctx, &firebase.Config{DatabaseURL: databaseURL}, conf)
+	app, err := firebase.NewApp(ctx, &firebase.Config{DatabaseURL: databaseURL}, opts...)
 	if err != nil {
-		return errors.Wrap(err, "failed to initialize Firebase app")
+		return nil, errors.Wrap(err, "failed to initialize Firebase app")
 	}
 
-	client, err = app.Database(ctx)
+	fb, err := app.Database(ctx)
 	if err != nil {
-		return errors.Wrap(err, "failed to initialize Firebase database")
+		return nil, errors.Wrap(err, "failed to initialize Firebase database")
 	}
-	return nil
+	return fb, nil
 }
 
-func handleAPI(c *gin.Context) {
-	var inst Instruction
-	if err := c.ShouldBindJSON(&inst); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{responseError: "Invalid request payload"})
-		return
+// connectFirebase initializes the Firebase client and checks that the
+// database answers, retrying with exponential backoff up to
+// FirebaseInitAttempts times. Dependencies often start in parallel with the
+// service, so a database that is briefly unreachable at boot should not
+// need a manual restart.
+func connectFirebase(cfg Config) (*db.Client, error) {
+	backoff := cfg.FirebaseInitBackoff
+	for attempt := 1; ; attempt++ {
+		fb, err := initFirebase(cfg)
+		if err == nil {
+			err = probeFirebase(fb, cfg.FirebaseTimeout)
+		}
+		if err == nil {
+			slog.Info("connected to Firebase", "attempt", attempt)
+			return fb, nil
+		}
+		if attempt >= cfg.FirebaseInitAttempts {
+			return nil, errors.Wrapf(err, "giving up on Firebase after %d attempt(s)", attempt)
+		}
+		slog.Warn("Firebase unavailable, retrying", "attempt", attempt, "max_attempts", cfg.FirebaseInitAttempts, "backoff", backoff.String(), "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 30*time.Second)
 	}
+}
 
-	aiResponse, err := getAIResponse(inst.Instruction)
-	log.Printf("AI Response: { \"target\": \"%s\", \"action\": \"%s\", \"content\": \"%s\", \"location\": \"%s\" }", aiResponse.Target, aiResponse.Action, aiResponse.Content, aiResponse.Location)
+// probeFirebase reads the health node, the same check /healthz does.
+func probeFirebase(fb *db.Client, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var v interface{}
+	return errors.Wrap(fb.NewRef(healthPath).Get(ctx, &v), "failed to read from Firebase")
+}
 
+func handleAPI(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		access := &accessEntry{start: time.Now()}
+		defer logAccess(c, cfg, access)
+		var inst Instruction
+		if err := c.ShouldBindJSON(&inst); err != nil {
+			status, body := bindingErrorResponse(err)
+			respond(c, status, body)
+			return
+		}
+		access.instruction = inst.Instruction
+		ctx, cancel := withBudget(c.Request.Context(), cfg.RequestBudget)
+		defer cancel()
+		access.rec = &commandRecorder{}
+		ctx = withModelOutput(withRecorder(ctx, access.rec), &modelOutput{})
+		c.Request = c.Request.WithContext(withOwnerPIN(ctx, inst.PIN))
+		instruction, aiResponse, status, body := classifyInstruction(ctx, cfg, inst)
+		access.aiLatency = time.Since(access.start)
+		if instruction != "" {
+			access.instruction = instruction
+		}
+		access.command = aiResponse
+		// A fallback answer may still be returned after the budget ran out,
+		// leaving no time to act on it.
+		if budgetStatus, budgetBody, ok := budgetFailure(ctx, stageAI); ok {
+			status, body = budgetStatus, budgetBody
+		}
+		if status != 0 {
+			respond(c, status, body)
+			return
+		}
+		executeCommand(c, instruction, aiResponse, inst.CallbackURL)
+	}
+}
+
+// classifyInstruction sanitizes and classifies an instruction for every
+// entry point (HTTP, batch and gRPC). It returns the cleaned instruction
+// and the command, or a non-zero status with the body to reply with.
+func classifyInstruction(ctx context.Context, cfg Config, inst Instruction) (string, AIResponse, int, gin.H) {
+	logger := logFromContext(ctx)
+	instruction, err := sanitizeInstruction(inst.Instruction, cfg.MaxInstructionLength)
+	if err != nil {
+		return "", AIResponse{}, http.StatusBadRequest, gin.H{responseError: err.Error()}
+	}
+	if inst.Model != "" && !cfg.modelAllowed(inst.Model) {
+		return "", AIResponse{}, http.StatusBadRequest, gin.H{responseError: fmt.Sprintf("Model %q is not allowed", inst.Model)}
+	}
+	logger.Info("instruction received", "instruction", logInstruction(instruction), "model", cfg.modelOrDefault(inst.Model))
+
+	aiResponse, err := getAIResponse(ctx, instruction, inst.Model)
+	logger.Info("AI response",
+		"target", aiResponse.Target,
+		"action", aiResponse.Action,
+		"content", aiResponse.Content,
+		"location", aiResponse.Location,
+		"locations", aiResponse.Locations,
+		"level", aiResponse.Level,
+		"temperature", aiResponse.Temperature,
+		"position", aiResponse.Position,
+		"volume", aiResponse.Volume,
+		"color", aiResponse.Color,
+		"delay", aiResponse.Delay,
+		"condition", aiResponse.Condition,
+		"error", logError(err),
+	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{responseError: fmt.Sprintf("Error from AI service: %v", err)})
+		status, body := aiErrorResponse(cfg, err)
+		return "", AIResponse{}, status, body
+	}
+	return instruction, aiResponse, 0, nil
+}
+
+// aiErrorResponse builds the status and body reporting a failed
+// classification.
+func aiErrorResponse(cfg Config, err error) (int, gin.H) {
+	if isTimeout(err) {
+		return http.StatusGatewayTimeout, gin.H{responseError: fmt.Sprintf("AI service did not respond within %s", cfg.AITimeout)}
+	}
+	if errors.Is(err, ErrAIBusy) {
+		return http.StatusServiceUnavailable, gin.H{responseError: err.Error()}
+	}
+	if errors.Is(err, ErrAIUnavailable) {
+		retryAfter := int(math.Ceil(aiBreaker.retryAfter().Seconds()))
+		return http.StatusServiceUnavailable, gin.H{responseError: err.Error(), "retry_after": retryAfter}
+	}
+	var actionErr *actionError
+	if errors.As(err, &actionErr) {
+		return errorResponse(err)
+	}
+	var modelErr *modelNotFoundError
+	if errors.As(err, &modelErr) {
+		return http.StatusBadGateway, gin.H{responseError: modelErr.Error(), "model": modelErr.Model, "hint": modelErr.hint()}
+	}
+	var statusErr *aiStatusError
+	if errors.As(err, &statusErr) {
+		return http.StatusBadGateway, gin.H{responseError: fmt.Sprintf("Error from AI service: %v", err)}
+	}
+	return http.StatusInternalServerError, gin.H{responseError: fmt.Sprintf("Error from AI service: %v", err)}
+}
+
+// sanitizeInstruction strips control characters from the instruction, which
+// have no place in a voice command and only widen the prompt-injection
+// surface, and enforces the configured length limit.
+func sanitizeInstruction(instruction string, maxLength int) (string, error) {
+	instruction = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, instruction)
+	instruction = strings.TrimSpace(instruction)
+
+	if instruction == "" {
+		return "", errors.New("Instruction must not be empty")
+	}
+	if n := utf8.RuneCountInString(instruction); n > maxLength {
+		return "", errors.Errorf("Instruction is %d characters long, the maximum is %d", n, maxLength)
+	}
+	return instruction, nil
+}
+
+// handleCommand executes a structured command without going through the AI
+// service, giving scripts a deterministic alternative to handleAPI.
+func handleCommand(c *gin.Context) {
+	// The PIN travels next to the command so that it never reaches the
+	// history or the logs along with it.
+	var req struct {
+		AIResponse
+		PIN string `json:"pin,omitempty"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, body := bindingErrorResponse(err)
+		respond(c, status, body)
 		return
 	}
+	command := req.AIResponse
+	c.Request = c.Request.WithContext(withOwnerPIN(c.Request.Context(), req.PIN))
+	if err := validateAIResponse(command); err != nil {
+		status, body := commandErrorResponse(err)
+		respond(c, status, body)
+		return
+	}
+	logFromContext(c.Request.Context()).Info("command received",
+		"target", command.Target,
+		"action", command.Action,
+		"location", command.Location,
+		"locations", command.Locations,
+	)
 
-	processAIResponse(c, aiResponse)
-}
-
-func getAIResponse(instruction string) (AIResponse, error) {
-	prompt := `When I give you a command, respond with a JSON object that contains the following keys:
-		- "target": the target of the action (e.g., "light", "door", etc.).
-		- "action": the action to perform (e.g., "on", "off", "open", "close", "play", etc.).
-		- "content": the content to search (leave an empty string "" if not specified).
-		- "location": the location of the target (e.g., "living room", "bedroom", "toilet", "kitchen", "all", or leave it empty "" if not specified).
-		
-		Instruction: ` + instruction + `
-		
-		Example:
-		- If the instruction is "turn on the light in the living room", the JSON object should be:
-		  {
-			"target": "light",
-			"action": "on",
-			"content": "",
-			"location": "living room"
-		  }
-		- If the instruction is "open the door", the JSON object should be:
-		  {
-			"target": "door",
-			"action": "open",
-			"content": "",
-			"location": ""
-		  }
-		- If the instruction is "turn on all the light", the JSON object should be:
-			{
-				"target": "light",
-				"action": "on",
-				"content": "",
-				"location": "all"
-			}
-		Please respond with only the JSON format. Do not include any additional explanation or text.`
+	executeCommand(c, "", command, "")
+}
 
-	payload := map[string]interface{}{
-		"model":  "phi3",
-		"prompt": fmt.Sprintf("<|system|>You are my Home AI assistant.<|end|><|user|>%s<|end|><|assistant|>", prompt),
-		"stream": false,
+// processAIResponse runs the command now, or schedules it when it carries
+// a delay, and returns the HTTP status and body describing the outcome.
+// Risky commands may first have to be confirmed.
+func processAIResponse(ctx context.Context, response AIResponse) (status int, body gin.H) {
+	ctx, span := startSpan(ctx, "command.process",
+		attribute.String("command.target", response.Target),
+		attribute.String("command.action", response.Action),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("command.status", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, fmt.Sprint(body[responseError]))
+		}
+		span.End()
+	}()
+
+	if status, body, ok := requireConfirmation(ctx, response); !ok {
+		return status, body
+	}
+	if response.Delay != nil {
+		delay, err := parseDelay(response.Delay)
+		if err != nil {
+			return http.StatusBadRequest, gin.H{responseError: err.Error()}
+		}
+		if delay > 0 {
+			task, err := scheduler.schedule(ctx, response, delay)
+			if err != nil {
+				return http.StatusServiceUnavailable, gin.H{responseError: err.Error()}
+			}
+			return http.StatusAccepted, gin.H{
+				"message": fmt.Sprintf("Scheduled %s %s in %s", response.Target, response.Action, delay),
+				"task":    task,
+			}
+		}
 	}
+	return runCommand(ctx, response)
+}
 
-	resp, err := http.Post("http://localhost:11434/api/generate", "application/json", bytes.NewReader(mustMarshal(payload)))
-	if err != nil {
-		return AIResponse{}, errors.Wrap(err, "failed to send request to AI service")
+// runCommand executes a validated command and returns the HTTP status and
+// body describing the outcome.
+func runCommand(ctx context.Context, response AIResponse) (int, gin.H) {
+	// Commands reach this point from the model, the keyword fallback, the
+	// scheduler and direct API calls; check them again right before acting.
+	if err := validateAIResponse(response); err != nil {
+		return commandErrorResponse(err)
+	}
+	response = response.withCanonicalAction()
+	if response.Condition != nil {
+		met, state, err := checkCondition(ctx, *response.Condition)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !met {
+			return conditionNotMet(*response.Condition, state)
+		}
 	}
-	defer resp.Body.Close()
+	commandsTotal.WithLabelValues(response.Target, response.Action).Inc()
+	handler, ok := targetHandlers[response.Target]
+	if !ok {
+		return errorResponse(ErrUnsupportedTarget)
+	}
+	rec := &commandRecorder{}
+	ctx = withWriteVerification(withRecorder(withTarget(ctx, response.Target), rec), response.Target)
+	status, body := handler.Handle(ctx, response)
+	if status < http.StatusMultipleChoices {
+		rec.mu.Lock()
+		describeCommand(body, response, rec.writes)
+		rec.mu.Unlock()
+	}
+	return status, body
+}
 
-	var data struct {
-		Response string `json:"response"`
+// describeCommand adds what a successful command changed to its body, so
+// that clients can update their view without reading the state back. A
+// single write is reported as path and value; several as writes.
+func describeCommand(body gin.H, response AIResponse, writes []deviceWrite) {
+	body["target"] = response.Target
+	body["action"] = response.Action
+	if len(response.Locations) > 0 {
+		body["locations"] = response.Locations
+	} else if response.Location != "" {
+		body["location"] = response.Location
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return AIResponse{}, errors.Wrap(err, "failed to decode AI response")
+	switch len(writes) {
+	case 0:
+	case 1:
+		body["path"] = writes[0].Path
+		body["value"] = writes[0].Value
+	default:
+		body["writes"] = writes
 	}
+}
 
-	var aiResponse AIResponse
-	if err := json.Unmarshal([]byte(data.Response), &aiResponse); err != nil {
-		return AIResponse{}, errors.Wrap(err, "failed to parse AI response JSON")
+// processBlind moves the blinds in every location; it handles both the
+// "blind" and "curtain" targets. Open and close move them all the way
+// unless a position is given; "set" requires one.
+func processBlind(ctx context.Context, response AIResponse) (int, gin.H) {
+	position, valid := map[string]int{"open": 100, "close": 0, "set": -1}[response.Action]
+	if !valid {
+		return errorResponse(unsupportedAction(response))
+	}
+	if response.Position != nil || position < 0 {
+		var err error
+		if position, err = parsePosition(response.Position); err != nil {
+			return http.StatusBadRequest, gin.H{responseError: err.Error()}
+		}
 	}
-	return aiResponse, nil
+
+	locations := response.targetLocations()
+	results := updateRoomDevices(ctx, "blind", turnField, locations, position)
+	return roomResultsResponse(results, fmt.Sprintf("Blind position set to %d%% in %s", position, strings.Join(locations, ", ")))
 }
 
-func processAIResponse(c *gin.Context, response AIResponse) {
-	ctx := context.Background()
-	action, valid := map[string]string{"on": actionOn, "off": actionOff, "open": actionOn, "close": actionOff}[response.Action]
+// processAC switches the air conditioner and writes its setpoint. A "set"
+// action turns the unit on at the requested temperature.
+func processAC(ctx context.Context, response AIResponse) (int, gin.H) {
+	action, valid := map[string]string{"on": actionOn, "off": actionOff, "set": actionOn}[response.Action]
 	if !valid {
-		c.JSON(http.StatusBadRequest, gin.H{responseError: "Invalid action"})
-		return
+		return errorResponse(unsupportedAction(response))
 	}
 
-	switch response.Target {
-	case "light":
-		if err := updateLight(ctx, response.Location, action); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{responseError: err.Error()})
-			return
+	var temp int
+	hasTemp := response.Temperature != nil
+	if hasTemp || response.Action == "set" {
+		var err error
+		if temp, err = parseTemperature(response.Temperature); err != nil {
+			return http.StatusBadRequest, gin.H{responseError: err.Error()}
 		}
-		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Light %s in %s", response.Action, response.Location)})
-	case "door":
-		var isOwner string
-		if err := client.NewRef("camera/isOwner").Get(ctx, &isOwner); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{responseError: "Failed to update door status"})
-			return
+	}
+
+	if err := setValue(ctx, acTurnPath, action); err != nil {
+		return writeFailure(err, "Failed to update air conditioner")
+	}
+	if action == actionOn && (hasTemp || response.Action == "set") {
+		if err := setValue(ctx, acTempPath, temp); err != nil {
+			return writeFailure(err, "Failed to update air conditioner temperature")
+		}
+		return http.StatusOK, gin.H{"message": fmt.Sprintf("Air conditioner set to %d°C", temp)}
+	}
+	return http.StatusOK, gin.H{"message": fmt.Sprintf("Air conditioner %s", response.Action)}
+}
+
+// mediaNodes maps each media target to the Firebase node its player
+// watches for "play" (on/off) and "query" (what to search for).
+var mediaNodes = map[string]string{"music": "media", "tv": "tv"}
+
+// processMedia starts playback of the searched content, or stops it.
+func processMedia(ctx context.Context, node string, response AIResponse) (int, gin.H) {
+	switch response.Action {
+	case "play":
+		query := strings.TrimSpace(response.Content)
+		if query == "" {
+			return http.StatusBadRequest, gin.H{responseError: "Play requires content to search for"}
+		}
+		if err := setValue(ctx, node+"/query", query); err != nil {
+			return writeFailure(err, "Failed to update media query")
+		}
+		if err := setValue(ctx, node+"/play", actionOn); err != nil {
+			return writeFailure(err, "Failed to start playback")
+		}
+		return http.StatusOK, gin.H{"message": fmt.Sprintf("Playing %s on %s", query, response.Target)}
+	case "stop", "pause", "off":
+		if err := setValue(ctx, node+"/play", actionOff); err != nil {
+			return writeFailure(err, "Failed to stop playback")
+		}
+		return http.StatusOK, gin.H{"message": fmt.Sprintf("Stopped %s", response.Target)}
+	default:
+		return errorResponse(unsupportedAction(response))
+	}
+}
+
+// allowAllTargets is whether commands may use the location "all"; it is set
+// up in main.
+var allowAllTargets = true
+
+// checkAllLocations refuses a command addressing every room when that is
+// disabled. It only applies to commands as they arrive: the steps of a
+// scene were written by the household and may still use "all".
+func checkAllLocations(response AIResponse) error {
+	if allowAllTargets {
+		return nil
+	}
+	for _, location := range response.targetLocations() {
+		if normalizeLocation(location) == "all" {
+			return ErrAllLocations
+		}
+	}
+	return nil
+}
+
+// targetLocations returns the rooms a command applies to.
+func (r AIResponse) targetLocations() []string {
+	if len(r.Locations) > 0 {
+		return r.Locations
+	}
+	return []string{r.Location}
+}
+
+// locationResult is the outcome of a device write in one location.
+type locationResult struct {
+	Location string `json:"location"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	err      error
+}
+
+// updateRoomDevices writes value in every location, carrying on past
+// failures so that one bad room does not prevent the others from updating.
+// "all" is expanded into its rooms so each of them gets its own result.
+func updateRoomDevices(ctx context.Context, target, field string, locations []string, value interface{}) []locationResult {
+	locations = expandLocations(target, locations)
+	if _, ok := backend.(multiSetter); ok && len(locations) > 1 {
+		return updateRoomDevicesAtomic(ctx, target, field, locations, value)
+	}
+	results := make([]locationResult, 0, len(locations))
+	for _, location := range locations {
+		result := locationResult{Location: location, OK: true}
+		if err := updateRoomDevice(ctx, target, field, location, value); err != nil {
+			result = locationResult{Location: location, Error: err.Error(), err: err}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// updateRoomDevicesAtomic writes value in every location with one update,
+// so the rooms either all change or all keep their state. Locations that
+// do not resolve are reported without blocking the others.
+func updateRoomDevicesAtomic(ctx context.Context, target, field string, locations []string, value interface{}) []locationResult {
+	results := make([]locationResult, len(locations))
+	values := make(map[string]interface{})
+	for i, location := range locations {
+		paths, err := resolveRoomPaths(target, field, location)
+		if err != nil {
+			results[i] = locationResult{Location: location, Error: err.Error(), err: err}
+			continue
 		}
-		if isOwner == "1" {
-			if err := client.NewRef(doorPath).Set(ctx, action); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{responseError: "Failed to update door status"})
-				return
+		for _, path := range paths {
+			values[path] = value
+		}
+		results[i] = locationResult{Location: location, OK: true}
+	}
+	if len(values) == 0 {
+		return results
+	}
+
+	if err := setValues(ctx, values); err != nil {
+		for i, result := range results {
+			if result.OK {
+				results[i] = locationResult{Location: result.Location, Error: err.Error(), err: err}
 			}
-		} else {
-			c.JSON(http.StatusOK, gin.H{"message": "You are not the owner"})
-			return
 		}
+	}
+	return results
+}
+
+// roomResultsResponse describes the outcome of a room-based command. A
+// single location keeps the plain message/error shape; several locations
+// add the per-location results and report a partial failure as 207
+// Multi-Status.
+func roomResultsResponse(results []locationResult, message string) (int, gin.H) {
+	failed, clientErrors, timeouts, denied, cooling := 0, 0, 0, 0, 0
+	for _, result := range results {
+		if !result.OK {
+			failed++
+			if isClientError(result.err) {
+				clientErrors++
+			}
+			if isTimeout(result.err) {
+				timeouts++
+			}
+			if errors.Is(result.err, ErrPermissionDenied) {
+				denied++
+			}
+			if errors.Is(result.err, ErrCooldown) {
+				cooling++
+			}
+		}
+	}
+
+	if len(results) == 1 {
+		if failed > 0 {
+			return errorResponse(results[0].err)
+		}
+		return http.StatusOK, gin.H{"message": message}
+	}
+
+	if failed > 0 {
+		status := http.StatusInternalServerError
+		switch {
+		case failed < len(results):
+			status = http.StatusMultiStatus
+		case clientErrors == failed:
+			status = http.StatusBadRequest
+		case timeouts == failed:
+			status = http.StatusGatewayTimeout
+		case denied == failed:
+			status = http.StatusForbidden
+		case cooling == failed:
+			status = http.StatusTooManyRequests
+		}
+		return status, gin.H{
+			responseError: fmt.Sprintf("%d of %d locations failed", failed, len(results)),
+			"results":     results,
+		}
+	}
+	return http.StatusOK, gin.H{"message": message, "results": results}
+}
 
-		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Door %s", response.Action)})
+// levelActions are the light actions that write a brightness level instead
+// of switching the light on or off.
+var levelActions = map[string]bool{"dim": true, "brightness": true, "set brightness": true}
+
+// levelSteps are the light actions that move the brightness relative to
+// its current level, by the sign of the step.
+var levelSteps = map[string]int{"increase": 1, "decrease": -1}
+
+// levelStep is the amount a relative adjustment moves the brightness when
+// the command gives none; it is set from LEVEL_STEP in main.
+var levelStep = defaultLevelStep
+
+// unknownLevel is the brightness assumed for a light that has never been
+// dimmed, which shines at full brightness.
+const unknownLevel = 100
+
+// adjustLevel returns the level current moved by delta, clamped to 0-100.
+// A missing or unreadable current level counts as unknownLevel.
+func adjustLevel(current interface{}, delta int) int {
+	level := unknownLevel
+	if f, err := parseNumber("level", current); err == nil {
+		level = int(math.Round(f))
+	}
+	return max(0, min(100, level+delta))
+}
+
+// parseLevel converts the level reported by the model into an integer
+// clamped to 0-100.
+func parseLevel(v interface{}) (int, error) {
+	f, err := parseNumber("level", v)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(math.Max(0, math.Min(100, f)))), nil
+}
+
+// parseTemperature converts the setpoint reported by the model and rejects
+// values outside the range the air conditioner supports.
+func parseTemperature(v interface{}) (int, error) {
+	f, err := parseNumber("temperature", v)
+	if err != nil {
+		return 0, err
+	}
+	temp := int(math.Round(f))
+	if temp < minACTemp || temp > maxACTemp {
+		return 0, errors.Errorf("Temperature must be between %d and %d°C", minACTemp, maxACTemp)
+	}
+	return temp, nil
+}
+
+// parsePosition converts the blind position reported by the model and
+// rejects values outside 0-100.
+func parsePosition(v interface{}) (int, error) {
+	f, err := parseNumber("position", v)
+	if err != nil {
+		return 0, err
+	}
+	position := int(math.Round(f))
+	if position < 0 || position > 100 {
+		return 0, errors.New("Position must be between 0 and 100")
+	}
+	return position, nil
+}
+
+// parseNumber reads a numeric field of the AI response. Numbers encoded as
+// strings (optionally with a unit suffix) are accepted as well.
+func parseNumber(name string, v interface{}) (float64, error) {
+	var f float64
+	switch n := v.(type) {
+	case nil:
+		return 0, errors.Errorf("Missing %s", name)
+	case float64:
+		f = n
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimRight(strings.TrimSpace(n), "%°CcFf "), 64)
+		if err != nil {
+			return 0, errors.Errorf("Invalid %s %q", name, n)
+		}
+		f = parsed
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{responseError: "Unsupported target"})
+		return 0, errors.Errorf("Invalid %s %v", name, n)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.Errorf("Invalid %s", name)
 	}
+	return f, nil
 }
 
-func updateLight(ctx context.Context, location, action string) error {
-	paths := map[string]string{
-		"living room": lightPrefix + "1/turn",
-		"bedroom":     lightPrefix + "2/turn",
-		"kitchen":     lightPrefix + "3/turn",
-		"toilet":      lightPrefix + "4/turn",
-		"wc":          lightPrefix + "4/turn",
+func updateRoomDevice(ctx context.Context, target, field, location string, value interface{}) error {
+	paths, err := resolveRoomPaths(target, field, location)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := setValue(ctx, path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toggleRoomDevices flips the turn state of target in every location.
+func toggleRoomDevices(ctx context.Context, target string, locations []string) []locationResult {
+	return modifyRoomDevices(ctx, target, turnField, locations, func(current interface{}) interface{} {
+		return toggleValue(current)
+	})
+}
+
+// modifyRoomDevices reads field of target in every location and writes back
+// the value next computes from the current one.
+func modifyRoomDevices(ctx context.Context, target, field string, locations []string, next func(current interface{}) interface{}) []locationResult {
+	locations = expandLocations(target, locations)
+	results := make([]locationResult, 0, len(locations))
+	for _, location := range locations {
+		result := locationResult{Location: location, OK: true}
+		if err := modifyRoomDevice(ctx, target, field, location, next); err != nil {
+			result = locationResult{Location: location, Error: err.Error(), err: err}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func modifyRoomDevice(ctx context.Context, target, field, location string, next func(current interface{}) interface{}) error {
+	paths, err := resolveRoomPaths(target, field, location)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		current, err := readState(ctx, path)
+		if err != nil {
+			return err
+		}
+		if err := setValue(ctx, path, next(current)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toggleValue returns the opposite of a stored turn value. Only actionOn is
+// considered on: a missing or unexpected value is treated as off, so
+// toggling a device in an unknown state turns it on.
+func toggleValue(current interface{}) string {
+	if current != nil && fmt.Sprint(current) == actionOn {
+		return actionOff
 	}
-	if location == "all" {
+	return actionOn
+}
+
+// setValue writes value to the given device path through the active
+// backend and logs the outcome. Dry runs only record the write.
+func setValue(ctx context.Context, path string, value interface{}) (err error) {
+	ctx, span := startSpan(ctx, "device.write",
+		attribute.String("device.path", path),
+		attribute.String("device.backend", backendName),
+		attribute.Bool("device.dry_run", isDryRun(ctx)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if err := checkWrite(ctx, path); err != nil {
+		return err
+	}
+	if isDryRun(ctx) {
+		logFromContext(ctx).Info("device write skipped (dry run)", "path", path, "value", value)
+		recordWrite(ctx, path, value, nil)
+		return nil
+	}
+	if err := cooldowns.reserve(ctx, path); err != nil {
+		return err
+	}
+	err = backend.Set(ctx, path, value)
+	logFromContext(ctx).Info("device write", "path", path, "value", value, "error", err)
+	if err == nil {
+		err = verifyWrite(ctx, path, value)
+	}
+	recordWrite(ctx, path, value, err)
+	if err != nil {
+		deviceWriteFailures.WithLabelValues(backendName).Inc()
+	}
+	return err
+}
+
+// setValues writes several paths in a single atomic update when the
+// backend supports it, so they either all change or none do. Other
+// backends get one write per path, stopping at the first failure.
+func setValues(ctx context.Context, values map[string]interface{}) (err error) {
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	multi, ok := backend.(multiSetter)
+	if !ok {
 		for _, path := range paths {
-			if err := client.NewRef(path).Set(ctx, action); err != nil {
-				return errors.Wrap(err, "failed to update all lights")
+			if err := setValue(ctx, path, values[path]); err != nil {
+				return err
 			}
 		}
 		return nil
 	}
-	path, exists := paths[location]
-	if !exists {
-		return errors.New("Invalid location")
+
+	ctx, span := startSpan(ctx, "device.write_many",
+		attribute.StringSlice("device.paths", paths),
+		attribute.String("device.backend", backendName),
+		attribute.Bool("device.dry_run", isDryRun(ctx)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	for _, path := range paths {
+		if err := checkWrite(ctx, path); err != nil {
+			return err
+		}
+	}
+	if isDryRun(ctx) {
+		for _, path := range paths {
+			logFromContext(ctx).Info("device write skipped (dry run)", "path", path, "value", values[path])
+			recordWrite(ctx, path, values[path], nil)
+		}
+		return nil
+	}
+	if err := cooldowns.reserve(ctx, paths...); err != nil {
+		return err
+	}
+	err = multi.SetMany(ctx, values)
+	logFromContext(ctx).Info("device write", "paths", paths, "values", values, "error", err)
+	for _, path := range paths {
+		if err != nil {
+			break
+		}
+		err = verifyWrite(ctx, path, values[path])
+	}
+	for _, path := range paths {
+		recordWrite(ctx, path, values[path], err)
+	}
+	if err != nil {
+		deviceWriteFailures.WithLabelValues(backendName).Inc()
 	}
-	return client.NewRef(path).Set(ctx, action)
+	return err
+}
+
+// checkWrite refuses writes to invalid paths and sensor readings, and to
+// owner-protected paths without a verified owner.
+func checkWrite(ctx context.Context, path string) error {
+	if err := validatePath(path); err != nil {
+		logFromContext(ctx).Error("refused write to invalid path", "path", path, "error", err)
+		return err
+	}
+	if sensorPath(path) {
+		logFromContext(ctx).Warn("refused write to sensor path", "path", path)
+		return ErrReadOnly
+	}
+	if ownerProtected(path) && !ownerVerified(ctx) {
+		logFromContext(ctx).Warn("refused write to owner-protected path", "path", path)
+		return ErrOwnerRequired
+	}
+	return nil
+}
+
+// isTimeout reports whether err was caused by a deadline being exceeded,
+// either from the HTTP client timeout or the request context.
+func isTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
 func mustMarshal(v interface{}) []byte {
 	b, err := json.Marshal(v)
 	if err != nil {
-		log.Fatalf("failed to marshal payload: %v", err)
+		fatal("failed to marshal payload", err)
 	}
 	return b
 }
 
 func main() {
-	if err := initFirebase(); err != nil {
-		log.Fatalf("Error initializing Firebase: %v", err)
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: &logLevel})))
+
+	printConfig := flag.Bool("print-config", false, "print the resolved configuration, with secrets redacted, and exit")
+	hashPIN := flag.Bool("hash-pin", false, "read a PIN from stdin, print its bcrypt hash for OWNER_PIN_HASH, and exit")
+	flag.Parse()
+	if *hashPIN {
+		if err := printPINHash(os.Stdin, os.Stdout); err != nil {
+			fatal("Error hashing PIN", err)
+		}
+		return
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal("Error loading configuration", err)
+	}
+	if *printConfig {
+		fmt.Print(cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		fatal("Invalid configuration", err)
+	}
+	if *printConfig {
+		return
+	}
+	httpClient.Timeout = cfg.AITimeout
+	httpClient.Transport = newAITransport(cfg)
+	if cfg.AIDebugLog {
+		logLevel.Set(slog.LevelDebug)
+		slog.Warn("AI_DEBUG_LOG is enabled, prompts and model output are logged verbatim")
+	}
+	watchLogLevel()
+
+	if _, err := reloadFiles(cfg); err != nil {
+		fatal("Error loading configuration files", err)
+	}
+	watchReload(cfg)
+
+	fb, err := connectFirebase(cfg)
+	if err != nil {
+		fatal("Error initializing Firebase", err)
+	}
+	store = firebaseBackend{client: fb, timeout: cfg.FirebaseTimeout}
+	ownerVerifier = newOwnerVerifier(cfg)
+	if backend, err = newBackend(cfg, fb); err != nil {
+		fatal("Error initializing device backend", err)
 	}
+	backendName = cfg.DeviceBackend
+	installWriteVerification(cfg.VerifyWrites)
+	webhooks = newWebhookNotifier(cfg)
+	commands = newCommandQueue(cfg.QueueWorkers, cfg.QueueSize)
+	cooldowns = newDeviceCooldown(cfg.DeviceCooldown, cfg.DeviceCooldowns)
+	aiBreaker = newCircuitBreaker(cfg.AIBreakerThreshold, cfg.AIBreakerCooldown)
+	if aiProvider, err = newFailoverProvider(cfg); err != nil {
+		fatal("Error initializing AI provider", err)
+	}
+	aiCache = newResponseCache(cfg.AICacheSize, cfg.AICacheTTL)
+	aiLimit = newAILimiter(cfg.AIMaxConcurrent, cfg.AIMaxQueue)
+	aiDowngrade = newModelDowngrader(cfg)
+	confirmations = newConfirmationStore(cfg.ConfirmRisky, cfg.ConfirmTTL)
+	doorDefaultLocation = cfg.DoorDefaultLocation
+	allowAllTargets = cfg.AllowAllTargets
+	levelStep = cfg.LevelStep
+	locationAutocorrect = cfg.LocationAutocorrect
+	redactInstructions = cfg.LogRedactInstructions
+	if cfg.DoorRequireLocation {
+		doorDefaultLocation = ""
+	}
+
+	shutdownTracing, err := initTracing(context.Background(), cfg)
+	if err != nil {
+		fatal("Error initializing tracing", err)
+	}
+
+	r, err := newRouter(cfg.TrustedProxies)
+	if err != nil {
+		fatal("Error creating router", err)
+	}
+	r.Use(gin.Recovery(), otelgin.Middleware(cfg.ServiceName), requestLogger(), limitBody(cfg.MaxBodyBytes))
+	r.GET("/healthz", handleHealth(cfg))
+	r.GET("/metrics", handleMetrics())
 
-	r := gin.Default()
-	r.POST("/api", handleAPI)
+	api := r.Group("/api")
+	ws := r.Group("/ws")
+	if len(cfg.APIKeys) > 0 {
+		api.Use(apiKeyAuth(cfg.APIKeys))
+		ws.Use(apiKeyAuth(cfg.APIKeys))
+		// The admin endpoints change how the service runs, so they only
+		// exist behind an API key.
+		admin := r.Group("/admin", apiKeyAuth(cfg.APIKeys))
+		admin.GET("/log-level", handleGetLogLevel)
+		admin.PUT("/log-level", handleSetLogLevel)
+		admin.POST("/reload", handleReload(cfg))
+	} else {
+		slog.Warn("no API_KEYS configured, the API is open to anyone who can reach it")
+	}
+	if cfg.RateLimitPerMinute > 0 {
+		api.Use(rateLimit(cfg.RateLimitPerMinute, cfg.RateLimitBurst))
+	}
+	ws.GET("/state", handleStateStream(newStateHub(cfg)))
+	idem := idempotency(cfg.IdempotencyTTL)
+	api.POST("", idem, handleAPI(cfg))
+	api.POST("/command", idem, handleCommand)
+	api.POST("/batch", idem, handleBatch(cfg))
+	api.POST("/scene/:name", idem, handleSceneRequest)
+	api.POST("/simulate", handleSimulate(cfg))
+	api.GET("/devices", handleDevices)
+	api.GET("/state/:target/:location", handleState)
+	api.GET("/schedules", handleSchedules)
+	api.GET("/history", handleHistory)
+	api.POST("/feedback", handleFeedback)
+	api.GET("/status", handleHouseStatus)
+
+	grpcServer, err := startGRPC(cfg)
+	if err != nil {
+		fatal("Error starting gRPC server", err)
+	}
+	if err := serve(cfg, r); err != nil {
+		fatal("Error running server", err)
+	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	// Queued commands may still schedule delayed ones and send webhooks,
+	// so they drain first.
+	commands.shutdown()
+	scheduler.shutdown()
+	webhooks.wait()
 
-	if err := r.Run(port); err != nil {
-		log.Fatalf("Error running server: %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
 	}
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errWriteFailed = errors.New("write failed")
+
+func TestUpdateRoomDevices(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		field     string
+		locations []string
+		fail      string
+		wantOK    []bool
+		wantErr   error
+		written   []string
+	}{
+		{name: "room", target: "light", field: turnField, locations: []string{"bedroom"}, wantOK: []bool{true}, written: []string{"light2/turn"}},
+		{name: "mixed case", target: "light", field: turnField, locations: []string{"The Kitchen"}, wantOK: []bool{true}, written: []string{"light3/turn"}},
+		{name: "all", target: "fan", field: turnField, locations: []string{"all"}, wantOK: []bool{true, true, true, true}, written: []string{"fan1/turn", "fan2/turn", "fan3/turn", "fan4/turn"}},
+		{name: "bad location", target: "light", field: turnField, locations: []string{"garage"}, wantOK: []bool{false}, wantErr: ErrInvalidLocation},
+		{name: "read-only sensor", target: sensorTarget, field: "temperature", locations: []string{"kitchen"}, wantOK: []bool{false}, wantErr: ErrReadOnly},
+		{name: "write error", target: "light", field: turnField, locations: []string{"bedroom"}, fail: "light2/turn", wantOK: []bool{false}, wantErr: errWriteFailed},
+		{name: "partial failure", target: "light", field: turnField, locations: []string{"bedroom", "kitchen"}, fail: "light2/turn", wantOK: []bool{false, true}, wantErr: errWriteFailed, written: []string{"light3/turn"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeBackend(t)
+			if tt.fail != "" {
+				f.fail[tt.fail] = errWriteFailed
+			}
+			results := updateRoomDevices(context.Background(), tt.target, tt.field, tt.locations, actionOn)
+			if len(results) != len(tt.wantOK) {
+				t.Fatalf("got %d results, want %d: %+v", len(results), len(tt.wantOK), results)
+			}
+			for i, result := range results {
+				if result.OK != tt.wantOK[i] {
+					t.Errorf("result %d: OK = %v, want %v (%s)", i, result.OK, tt.wantOK[i], result.Error)
+				}
+				if !result.OK && !errors.Is(result.err, tt.wantErr) {
+					t.Errorf("result %d: error %v, want %v", i, result.err, tt.wantErr)
+				}
+			}
+			if got := f.written(); !slices.Equal(got, tt.written) {
+				t.Errorf("written %v, want %v", got, tt.written)
+			}
+		})
+	}
+}
+
+func TestProcessAIResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		command    AIResponse
+		owner      OwnerResult
+		fail       string
+		wantStatus int
+		wantWrites map[string]interface{}
+	}{
+		{name: "light on", command: AIResponse{Target: "light", Action: "on", Location: "bedroom"}, wantStatus: http.StatusOK, wantWrites: map[string]interface{}{"light2/turn": actionOn}},
+		{name: "fan off", command: AIResponse{Target: "fan", Action: "off", Location: "kitchen"}, wantStatus: http.StatusOK, wantWrites: map[string]interface{}{"fan3/turn": actionOff}},
+		{name: "synonym", command: AIResponse{Target: "light", Action: "activate", Location: "bedroom"}, wantStatus: http.StatusOK, wantWrites: map[string]interface{}{"light2/turn": actionOn}},
+		{name: "light level", command: AIResponse{Target: "light", Action: "dim", Location: "bedroom", Level: 40.0}, wantStatus: http.StatusOK, wantWrites: map[string]interface{}{"light2/level": 40}},
+		{name: "ac set", command: AIResponse{Target: "ac", Action: "set", Temperature: 24.0}, wantStatus: http.StatusOK, wantWrites: map[string]interface{}{acTurnPath: actionOn, acTempPath: 24}},
+		{name: "door open", command: AIResponse{Target: "door", Action: "open", Location: "front"}, owner: OwnerAuthorized, wantStatus: http.StatusOK, wantWrites: map[string]interface{}{"door/turn": actionOn}},
+		{name: "door not owner", command: AIResponse{Target: "door", Action: "open", Location: "front"}, owner: OwnerDenied, wantStatus: http.StatusOK},
+		{name: "door verification error", command: AIResponse{Target: "door", Action: "open", Location: "front"}, owner: OwnerError, wantStatus: http.StatusInternalServerError},
+		{name: "invalid action", command: AIResponse{Target: "fan", Action: "explode", Location: "kitchen"}, wantStatus: http.StatusUnprocessableEntity},
+		{name: "unsupported target", command: AIResponse{Target: "oven", Action: "on"}, wantStatus: http.StatusBadRequest},
+		{name: "all rooms partly failing", command: AIResponse{Target: "light", Action: "on", Locations: []string{"bedroom", "kitchen"}}, fail: "light2/turn", wantStatus: http.StatusMultiStatus, wantWrites: map[string]interface{}{"light3/turn": actionOn}},
+		{name: "write error", command: AIResponse{Target: "light", Action: "off", Location: "bedroom"}, fail: "light2/turn", wantStatus: http.StatusInternalServerError},
+		{name: "bad location", command: AIResponse{Target: "light", Action: "on", Location: "garage"}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeBackend(t)
+			ownerVerifier = fakeOwner(tt.owner)
+			if tt.fail != "" {
+				f.fail[tt.fail] = errWriteFailed
+			}
+			status, body := processAIResponse(context.Background(), tt.command.withCanonicalAction())
+			if status != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if len(f.writes) != len(tt.wantWrites) {
+				t.Fatalf("writes %+v, want %v", f.writes, tt.wantWrites)
+			}
+			for _, w := range f.writes {
+				if want, ok := tt.wantWrites[w.Path]; !ok || w.Value != want {
+					t.Errorf("wrote %v to %s, want %v", w.Value, w.Path, tt.wantWrites)
+				}
+			}
+		})
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		command    AIResponse
+		owner      OwnerResult
+		wantStatus int
+		wantWrites int
+	}{
+		{name: "valid", command: AIResponse{Target: "light", Action: "on", Location: "kitchen"}, owner: OwnerAuthorized, wantStatus: http.StatusOK, wantWrites: 1},
+		{name: "unknown target", command: AIResponse{Target: "garage", Action: "open"}, owner: OwnerAuthorized, wantStatus: http.StatusBadRequest},
+		{name: "unsupported action", command: AIResponse{Target: "fan", Action: "dim", Location: "kitchen"}, owner: OwnerAuthorized, wantStatus: http.StatusUnprocessableEntity},
+		{name: "bad location", command: AIResponse{Target: "fan", Action: "on", Location: "attic"}, owner: OwnerAuthorized, wantStatus: http.StatusBadRequest},
+		{name: "read-only sensor", command: AIResponse{Target: sensorTarget, Action: "get", Content: "temperature", Location: "kitchen"}, owner: OwnerAuthorized, wantStatus: http.StatusOK},
+		{name: "door needs owner", command: AIResponse{Target: "door", Action: "open", Location: "front"}, owner: OwnerDenied, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeBackend(t)
+			ownerVerifier = fakeOwner(tt.owner)
+			status, body := runCommand(context.Background(), tt.command)
+			if status != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if len(f.writes) != tt.wantWrites {
+				t.Errorf("writes %+v, want %d", f.writes, tt.wantWrites)
+			}
+		})
+	}
+}
+
+func TestSanitizeInstruction(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction string
+		want        string
+		err         bool
+	}{
+		{name: "plain", instruction: "turn on the light", want: "turn on the light"},
+		{name: "trimmed", instruction: "  turn on the light\n", want: "turn on the light"},
+		{name: "control characters", instruction: "turn\x00 on\x1b the\tlight", want: "turn on the light"},
+		{name: "empty", instruction: "", err: true},
+		{name: "only whitespace and controls", instruction: " \t\x00\x07 ", err: true},
+		{name: "at the limit", instruction: strings.Repeat("a", 20), want: strings.Repeat("a", 20)},
+		{name: "over the limit", instruction: strings.Repeat("a", 21), err: true},
+		{name: "multibyte at the limit", instruction: strings.Repeat("đ", 20), want: strings.Repeat("đ", 20)},
+		{name: "limit counted after stripping", instruction: strings.Repeat("a", 20) + "\x00\x00", want: strings.Repeat("a", 20)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeInstruction(tt.instruction, 20)
+			if (err != nil) != tt.err {
+				t.Fatalf("sanitizeInstruction() error = %v, want error %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("sanitizeInstruction() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllLocationsStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       []string
+		wantStatus int
+		wantFailed []string
+	}{
+		{name: "all succeed", wantStatus: http.StatusOK},
+		{name: "mid-loop failure", fail: []string{"light2/turn"}, wantStatus: http.StatusMultiStatus, wantFailed: []string{"bedroom"}},
+		{name: "all fail", fail: []string{"light1/turn", "light2/turn", "light3/turn", "light4/turn"}, wantStatus: http.StatusInternalServerError, wantFailed: []string{"bedroom", "kitchen", "living room", "toilet"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeBackend(t)
+			for _, path := range tt.fail {
+				f.fail[path] = errWriteFailed
+			}
+			status, body := processAIResponse(context.Background(), AIResponse{Target: "light", Action: "on", Location: "all"})
+			if status != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			results, _ := body["results"].([]locationResult)
+			if len(results) != 4 {
+				t.Fatalf("results %+v, want one per room", body["results"])
+			}
+			var failed []string
+			for _, result := range results {
+				if !result.OK {
+					failed = append(failed, result.Location)
+				}
+			}
+			slices.Sort(failed)
+			if !slices.Equal(failed, tt.wantFailed) {
+				t.Errorf("failed rooms %v, want %v", failed, tt.wantFailed)
+			}
+			if got := len(f.writes); got != 4-len(tt.fail) {
+				t.Errorf("%d writes, want %d", got, 4-len(tt.fail))
+			}
+		})
+	}
+}
+
+func TestNewAITransport(t *testing.T) {
+	tr := newAITransport(Config{AIMaxIdleConns: 50, AIMaxIdleConnsPerHost: 8, AIIdleConnTimeout: time.Minute})
+	if tr.MaxIdleConns != 50 || tr.MaxIdleConnsPerHost != 8 || tr.IdleConnTimeout != time.Minute {
+		t.Fatalf("transport idle settings %d/%d/%s, want 50/8/1m0s", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout)
+	}
+	if tr == http.DefaultTransport {
+		t.Fatal("the default transport was modified")
+	}
+}
+
+// BenchmarkAITransport sends bursts of concurrent requests to a mock AI
+// service with the default transport and with the tuned one, reporting how
+// many connections were opened per request. The default keeps only two
+// idle connections per host, so every burst reconnects.
+func BenchmarkAITransport(b *testing.B) {
+	const burst = 16
+	tuned := newAITransport(Config{AIMaxIdleConns: defaultAIMaxIdleConns, AIMaxIdleConnsPerHost: defaultAIMaxIdlePerHost, AIIdleConnTimeout: defaultAIIdleConnTimeout})
+	for _, bench := range []struct {
+		name      string
+		transport *http.Transport
+	}{
+		{"default", http.DefaultTransport.(*http.Transport).Clone()},
+		{"tuned", tuned},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var conns atomic.Int64
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// A little latency keeps the whole burst in flight.
+				time.Sleep(time.Millisecond)
+				_, _ = w.Write([]byte(`{"response":"{}"}`))
+			}))
+			srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			srv.Start()
+			defer srv.Close()
+			defer bench.transport.CloseIdleConnections()
+
+			prev := httpClient
+			httpClient = &http.Client{Timeout: 5 * time.Second, Transport: bench.transport}
+			defer func() { httpClient = prev }()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for j := 0; j < burst; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						resp, err := postAI(context.Background(), srv.URL, nil, []byte("{}"))
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						drainAndClose(resp.Body)
+					}()
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(conns.Load())/float64(b.N*burst), "conns/req")
+		})
+	}
+}
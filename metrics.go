@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	commandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iot_commands_total",
+		Help: "Commands processed, by target and action.",
+	}, []string{"target", "action"})
+
+	aiRequestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "iot_ai_request_duration_seconds",
+		Help:    "Latency of AI classification requests.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+
+	aiRequestErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "iot_ai_request_errors_total",
+		Help: "AI classification requests that failed.",
+	})
+
+	aiProviderRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iot_ai_provider_requests_total",
+		Help: "Instructions classified, by the AI provider that answered.",
+	}, []string{"provider"})
+
+	aiFailovers = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "iot_ai_failovers_total",
+		Help: "AI requests handed to the next provider after one failed.",
+	})
+
+	aiFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "iot_ai_fallbacks_total",
+		Help: "Instructions classified by the keyword fallback after the AI request failed.",
+	})
+
+	aiCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iot_ai_cache_requests_total",
+		Help: "AI response cache lookups, by result (hit or miss).",
+	}, []string{"result"})
+
+	aiRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "iot_ai_requests_in_flight",
+		Help: "AI classification requests currently being processed.",
+	})
+
+	aiRequestsQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "iot_ai_requests_queued",
+		Help: "AI classification requests waiting for a free slot.",
+	})
+
+	aiBreakerState = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "iot_ai_breaker_state",
+		Help: "State of the AI circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iot_webhook_deliveries_total",
+		Help: "Completion webhooks sent, by result (delivered or failed).",
+	}, []string{"result"})
+
+	aiActiveModel = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "iot_ai_active_model",
+		Help: "Model serving requests for the default model (1 for the active one) while latency downgrade is enabled.",
+	}, []string{"model"})
+
+	aiPrimaryLatency = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "iot_ai_primary_latency_seconds",
+		Help: "Exponentially smoothed latency of the default AI model.",
+	})
+
+	commandQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "iot_command_queue_depth",
+		Help: "Async commands waiting for a worker.",
+	})
+
+	classificationFeedback = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iot_ai_classification_feedback_total",
+		Help: "Feedback on classified commands, by verdict (good or bad) and by source (model, cache or fallback).",
+	}, []string{"verdict", "source"})
+
+	deviceWriteFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "iot_device_write_failures_total",
+		Help: "Device writes that failed, by backend.",
+	}, []string{"backend"})
+)
+
+func handleMetrics() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const providerMock = "mock"
+
+// mockRule answers every instruction containing Match (case-insensitively)
+// with Response.
+type mockRule struct {
+	Match    string     `json:"match"`
+	Response AIResponse `json:"response"`
+}
+
+// mockProvider returns canned commands from a table instead of calling a
+// model, so end-to-end tests run without an AI service. Rules are tried in
+// file order and the first match wins.
+type mockProvider struct {
+	rules []mockRule
+}
+
+func newMockProvider(file string) (mockProvider, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return mockProvider{}, errors.Wrap(err, "failed to read mock AI file")
+	}
+	var rules []mockRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return mockProvider{}, errors.Wrapf(err, "failed to parse mock AI file %s", file)
+	}
+	for i, rule := range rules {
+		if strings.TrimSpace(rule.Match) == "" {
+			return mockProvider{}, errors.Errorf("invalid mock AI file %s: rule %d has an empty match", file, i+1)
+		}
+		if err := validateAIResponse(rule.Response); err != nil {
+			return mockProvider{}, errors.Wrapf(err, "invalid mock AI file %s: rule %d", file, i+1)
+		}
+		rules[i].Match = strings.ToLower(rule.Match)
+	}
+	return mockProvider{rules: rules}, nil
+}
+
+func (p mockProvider) Classify(ctx context.Context, instruction, _ string) (AIResponse, error) {
+	text := strings.ToLower(instruction)
+	for _, rule := range p.rules {
+		if strings.Contains(text, rule.Match) {
+			logFromContext(ctx).Debug("mock AI response", "match", rule.Match)
+			if raw, err := json.Marshal(rule.Response); err == nil {
+				captureModelOutput(ctx, string(raw), nil)
+			}
+			return rule.Response, nil
+		}
+	}
+	return AIResponse{}, &quotedTextError{msg: "no mock AI response matches", text: instruction}
+}
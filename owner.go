@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Owner verification modes of OWNER_MODE.
+const (
+	ownerModeCamera      = "camera"
+	ownerModePIN         = "pin"
+	ownerModeCameraOrPIN = "camera_or_pin"
+)
+
+// OwnerResult is the outcome of an owner verification.
+type OwnerResult int
+
+const (
+	// OwnerError means the verifier could not reach a decision.
+	OwnerError OwnerResult = iota
+	OwnerAuthorized
+	OwnerDenied
+)
+
+func (r OwnerResult) String() string {
+	switch r {
+	case OwnerAuthorized:
+		return "authorized"
+	case OwnerDenied:
+		return "denied"
+	default:
+		return "error"
+	}
+}
+
+// OwnerVerifier decides whether the person issuing a command is allowed to
+// operate the door.
+type OwnerVerifier interface {
+	Verify(ctx context.Context) (OwnerResult, error)
+}
+
+// ownerVerifier is the active OwnerVerifier, set up in main.
+var ownerVerifier OwnerVerifier
+
+// ErrOwnerRequired is returned when an owner-protected path is written
+// without a successful owner verification.
+var ErrOwnerRequired = errors.New("Owner verification required")
+
+type ownerVerifiedKey struct{}
+
+// withOwnerVerified marks ctx as belonging to a verified owner.
+func withOwnerVerified(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ownerVerifiedKey{}, true)
+}
+
+// ownerProtected reports whether writing path, the relay or the lock of a
+// door, requires the owner. The check lives next to the write itself, so
+// no command the model makes up can reach the door without going through
+// the verifier.
+func ownerProtected(path string) bool {
+	for _, doorPath := range (*registry.Load())["door"] {
+		if path == doorPath || path == fieldPath(doorPath, lockField) {
+			return true
+		}
+	}
+	return false
+}
+
+func ownerVerified(ctx context.Context) bool {
+	verified, _ := ctx.Value(ownerVerifiedKey{}).(bool)
+	return verified
+}
+
+// firebaseOwnerVerifier trusts the flag the door camera stores in Firebase
+// after recognising the owner.
+type firebaseOwnerVerifier struct {
+	path string
+}
+
+func (v firebaseOwnerVerifier) Verify(ctx context.Context) (OwnerResult, error) {
+	var isOwner interface{}
+	if err := store.Get(ctx, v.path, &isOwner); err != nil {
+		return OwnerError, errors.Wrap(err, "failed to read owner flag")
+	}
+	owner, ok := parseOwnerFlag(isOwner)
+	if !ok {
+		logFromContext(ctx).Warn("owner flag missing or not understood, treating as not owner", "path", v.path, "value", isOwner)
+	}
+	if owner {
+		return OwnerAuthorized, nil
+	}
+	return OwnerDenied, nil
+}
+
+// parseOwnerFlag reads the owner flag whichever way the camera stored it:
+// the string "1" or "0", a boolean, or a number. The second result is false
+// when the flag is missing or holds anything else, which never counts as
+// the owner.
+func parseOwnerFlag(v interface{}) (owner, ok bool) {
+	switch flag := v.(type) {
+	case bool:
+		return flag, true
+	case float64:
+		return flag == 1, flag == 1 || flag == 0
+	case string:
+		switch strings.ToLower(strings.TrimSpace(flag)) {
+		case "1", "true":
+			return true, true
+		case "0", "false":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// pinOwnerVerifier authorizes a command carrying a PIN that matches the
+// bcrypt hash of the owner's PIN. The PIN itself is never logged.
+type pinOwnerVerifier struct {
+	hash []byte
+}
+
+func (v pinOwnerVerifier) Verify(ctx context.Context) (OwnerResult, error) {
+	pin := ownerPINFromContext(ctx)
+	if pin == "" {
+		return OwnerDenied, nil
+	}
+	if err := bcrypt.CompareHashAndPassword(v.hash, []byte(pin)); err != nil {
+		logFromContext(ctx).Warn("owner PIN rejected")
+		return OwnerDenied, nil
+	}
+	return OwnerAuthorized, nil
+}
+
+// anyOwnerVerifier authorizes the owner as soon as one of its verifiers
+// does. It only fails when no verifier authorized and one of them could not
+// decide.
+type anyOwnerVerifier []OwnerVerifier
+
+func (verifiers anyOwnerVerifier) Verify(ctx context.Context) (OwnerResult, error) {
+	result, err := OwnerDenied, error(nil)
+	for _, v := range verifiers {
+		r, verr := v.Verify(ctx)
+		switch r {
+		case OwnerAuthorized:
+			return OwnerAuthorized, nil
+		case OwnerError:
+			if result != OwnerError {
+				result, err = OwnerError, verr
+			}
+		}
+	}
+	return result, err
+}
+
+// newOwnerVerifier returns the verifier selected by cfg.OwnerMode. A PIN
+// is checked before the camera flag, which costs a database read.
+func newOwnerVerifier(cfg Config) OwnerVerifier {
+	camera := firebaseOwnerVerifier{path: cfg.OwnerPath}
+	pin := pinOwnerVerifier{hash: []byte(cfg.OwnerPINHash)}
+	switch cfg.OwnerMode {
+	case ownerModePIN:
+		return pin
+	case ownerModeCameraOrPIN:
+		return anyOwnerVerifier{pin, camera}
+	default:
+		return camera
+	}
+}
+
+type ownerPINKey struct{}
+
+// withOwnerPIN returns ctx carrying the PIN sent with the command, if any.
+func withOwnerPIN(ctx context.Context, pin string) context.Context {
+	if pin == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ownerPINKey{}, pin)
+}
+
+func ownerPINFromContext(ctx context.Context) string {
+	pin, _ := ctx.Value(ownerPINKey{}).(string)
+	return pin
+}
+
+// printPINHash reads a PIN from the first line of r and writes its bcrypt
+// hash, the value of OWNER_PIN_HASH, to w.
+func printPINHash(r io.Reader, w io.Writer) error {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "failed to read PIN")
+	}
+	pin := strings.TrimSpace(line)
+	if pin == "" {
+		return errors.New("empty PIN")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "failed to hash PIN")
+	}
+	_, err = fmt.Fprintln(w, string(hash))
+	return err
+}
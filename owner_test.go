@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestFirebaseOwnerVerifier(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored interface{}
+		want   OwnerResult
+	}{
+		{name: "string 1", stored: "1", want: OwnerAuthorized},
+		{name: "string 0", stored: "0", want: OwnerDenied},
+		{name: "true", stored: true, want: OwnerAuthorized},
+		{name: "false", stored: false, want: OwnerDenied},
+		{name: "number 1", stored: 1, want: OwnerAuthorized},
+		{name: "number 0", stored: 0, want: OwnerDenied},
+		{name: "string true", stored: " TRUE ", want: OwnerAuthorized},
+		{name: "missing", stored: nil, want: OwnerDenied},
+		{name: "other number", stored: 2, want: OwnerDenied},
+		{name: "other string", stored: "yes", want: OwnerDenied},
+		{name: "object", stored: map[string]interface{}{"owner": true}, want: OwnerDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeBackend(t)
+			if tt.stored != nil {
+				f.state[defaultOwnerPath] = tt.stored
+			}
+			got, err := firebaseOwnerVerifier{path: defaultOwnerPath}.Verify(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirebaseOwnerVerifierReadError(t *testing.T) {
+	f := useFakeBackend(t)
+	f.fail[defaultOwnerPath] = errors.New("read failed")
+	if got, err := (firebaseOwnerVerifier{path: defaultOwnerPath}).Verify(context.Background()); err == nil || got != OwnerError {
+		t.Fatalf("Verify() = %v, %v, want OwnerError", got, err)
+	}
+}
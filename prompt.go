@@ -0,0 +1,84 @@
+package main
+
+import (
+	_ "embed"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// defaultPromptTemplate is the prompt used when PROMPT_TEMPLATE_FILE is not
+// set.
+//
+//go:embed prompt.tmpl
+var defaultPromptTemplate string
+
+// promptData is what a prompt template is rendered with. Instruction is
+// already stripped of markup and quoted as a JSON string, so templates
+// cannot forget to delimit it.
+type promptData struct {
+	Instruction string
+	Rooms       []string
+	Zones       []string
+	Scenes      []string
+	Colors      []string
+	Targets     []promptTarget
+}
+
+// promptTarget is a target and the actions it supports.
+type promptTarget struct {
+	Name    string
+	Actions []string
+}
+
+var promptFuncs = template.FuncMap{"quoteList": quoteList}
+
+// promptTemplate holds the active prompt template.
+var promptTemplate atomic.Pointer[template.Template]
+
+func init() {
+	promptTemplate.Store(template.Must(parsePromptTemplate(defaultPromptTemplate)))
+}
+
+func parsePromptTemplate(text string) (*template.Template, error) {
+	return template.New("prompt").Funcs(promptFuncs).Option("missingkey=error").Parse(text)
+}
+
+// loadPromptTemplate returns the template in file, or the default one when
+// file is empty, along with its source. The template is rendered once with
+// sample data so that references to unknown fields fail when it is loaded
+// rather than per request.
+func loadPromptTemplate(file string) (*template.Template, string, error) {
+	if file == "" {
+		t, err := parsePromptTemplate(defaultPromptTemplate)
+		return t, defaultPromptTemplate, err
+	}
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to read prompt template")
+	}
+	t, err := parsePromptTemplate(string(text))
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to parse prompt template %s", file)
+	}
+	sample := promptData{Instruction: quoteInstruction("turn on the light"), Rooms: []string{"living room"}, Colors: colorNames(), Targets: promptTargets()}
+	if err := t.Execute(&strings.Builder{}, sample); err != nil {
+		return nil, "", errors.Wrapf(err, "invalid prompt template %s", file)
+	}
+	return t, string(text), nil
+}
+
+// promptTargets returns every registered target with its actions, sorted by
+// name.
+func promptTargets() []promptTarget {
+	targets := make([]promptTarget, 0, len(targetActions))
+	for name, actions := range targetActions {
+		targets = append(targets, promptTarget{Name: name, Actions: actions})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	return targets
+}
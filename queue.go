@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// asyncHeader and the async query parameter ask for a command to be queued
+// and answered with 202 Accepted before its device writes are done.
+const asyncHeader = "X-Async"
+
+// ErrQueueFull is returned when the command queue holds as many jobs as it
+// may.
+var ErrQueueFull = errors.New("Too many queued commands, please retry")
+
+// queuedJob is a resolved command waiting for a worker.
+type queuedJob struct {
+	ID          string
+	ctx         context.Context
+	instruction string
+	command     AIResponse
+}
+
+// commandQueue runs the commands of async requests on a fixed pool of
+// workers, so the reply does not wait for the database. The outcome goes
+// to the history and the webhook like any other command. A nil
+// *commandQueue runs every command synchronously.
+type commandQueue struct {
+	jobs chan queuedJob
+	wg   sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// commands is the active queue, set up in main.
+var commands *commandQueue
+
+func newCommandQueue(workers, size int) *commandQueue {
+	if workers <= 0 {
+		return nil
+	}
+	q := &commandQueue{jobs: make(chan queuedJob, size)}
+	q.wg.Add(workers)
+	for range workers {
+		go q.work()
+	}
+	return q
+}
+
+func (q *commandQueue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		commandQueueDepth.Dec()
+		status, body := recordCommand(job.ctx, job.instruction, job.command, false)
+		logFromContext(job.ctx).Info("queued command executed", "job_id", job.ID, "status", status, "result", body)
+	}
+}
+
+// enqueue hands the command to a worker and returns the 202 reply, or an
+// error reply when the command is rejected up front or the queue is full
+// or shutting down. The job keeps the request-scoped values of ctx but not
+// its cancellation.
+func (q *commandQueue) enqueue(ctx context.Context, instruction string, command AIResponse) (int, gin.H) {
+	if err := checkAllLocations(command.withCanonicalAction()); err != nil {
+		return errorResponse(err)
+	}
+	job := queuedJob{ID: uuid.NewString(), ctx: context.WithoutCancel(ctx), instruction: instruction, command: command}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return http.StatusServiceUnavailable, gin.H{responseError: "command queue is shutting down"}
+	}
+	select {
+	case q.jobs <- job:
+	default:
+		return http.StatusServiceUnavailable, gin.H{responseError: ErrQueueFull.Error()}
+	}
+	commandQueueDepth.Inc()
+	logFromContext(ctx).Info("command queued", "job_id", job.ID)
+	return http.StatusAccepted, gin.H{
+		"message": fmt.Sprintf("Queued %s %s", command.Target, command.Action),
+		"job_id":  job.ID,
+	}
+}
+
+// shutdown stops accepting jobs and waits for the workers to run the ones
+// already queued.
+func (q *commandQueue) shutdown() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.closed = true
+	close(q.jobs)
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// enabled reports whether async requests are queued at all.
+func (q *commandQueue) enabled() bool {
+	return q != nil
+}
+
+// asyncRequested reports whether the request asks for its command to be
+// queued, with ?async=true or the X-Async header.
+func asyncRequested(c *gin.Context) bool {
+	for _, v := range []string{c.Query("async"), c.GetHeader(asyncHeader)} {
+		if async, err := strconv.ParseBool(v); err == nil && async {
+			return true
+		}
+	}
+	return false
+}
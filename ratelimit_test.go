@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newRateLimitedRouter(t *testing.T, keys []string, perMinute, burst int, trustedProxies ...string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r, err := newRouter(trustedProxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := r.Group("/api")
+	if len(keys) > 0 {
+		api.Use(apiKeyAuth(keys))
+	}
+	api.Use(rateLimit(perMinute, burst))
+	api.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func get(r http.Handler, auth string) *httptest.ResponseRecorder {
+	return getForwarded(r, auth, "")
+}
+
+// getForwarded sends a request from 192.0.2.1 claiming to be forwarded for
+// forwardedFor.
+func getForwarded(r http.Handler, auth, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/ping", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	r := newRateLimitedRouter(t, nil, 60, 3)
+	for i := 0; i < 3; i++ {
+		if w := get(r, ""); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i+1, w.Code)
+		}
+	}
+	w := get(r, "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the burst: status %d, want 429", w.Code)
+	}
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 {
+		t.Errorf("Retry-After %q, want a positive number of seconds", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitIgnoresUncheckedKeys(t *testing.T) {
+	// Without API_KEYS, a made-up bearer token must not buy a new bucket.
+	r := newRateLimitedRouter(t, nil, 60, 2)
+	statuses := []int{get(r, "Bearer a").Code, get(r, "Bearer b").Code, get(r, "Bearer c").Code}
+	if statuses[2] != http.StatusTooManyRequests {
+		t.Errorf("statuses %v, want the third request limited", statuses)
+	}
+}
+
+func TestRateLimitPerAcceptedKey(t *testing.T) {
+	r := newRateLimitedRouter(t, []string{"k1", "k2"}, 60, 1)
+	if w := get(r, "Bearer k1"); w.Code != http.StatusOK {
+		t.Fatalf("k1: status %d, want 200", w.Code)
+	}
+	if w := get(r, "Bearer k1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("k1 again: status %d, want 429", w.Code)
+	}
+	if w := get(r, "Bearer k2"); w.Code != http.StatusOK {
+		t.Errorf("k2 from the same address: status %d, want 200", w.Code)
+	}
+	if w := get(r, "Bearer wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid key: status %d, want 401", w.Code)
+	}
+}
+
+func TestRateLimitIgnoresForwardedFor(t *testing.T) {
+	// A client rotating X-Forwarded-For must stay in the bucket of its
+	// own address.
+	r := newRateLimitedRouter(t, nil, 60, 2)
+	var codes []int
+	for i := 0; i < 3; i++ {
+		codes = append(codes, getForwarded(r, "", fmt.Sprintf("203.0.113.%d", i+1)).Code)
+	}
+	if codes[2] != http.StatusTooManyRequests {
+		t.Errorf("statuses %v, want the third request limited", codes)
+	}
+}
+
+func TestRateLimitTrustedProxy(t *testing.T) {
+	// Behind a trusted proxy, each forwarded client has its own bucket.
+	r := newRateLimitedRouter(t, nil, 60, 1, "192.0.2.0/24")
+	if w := getForwarded(r, "", "203.0.113.1"); w.Code != http.StatusOK {
+		t.Fatalf("first client: status %d, want 200", w.Code)
+	}
+	if w := getForwarded(r, "", "203.0.113.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("first client again: status %d, want 429", w.Code)
+	}
+	if w := getForwarded(r, "", "203.0.113.2"); w.Code != http.StatusOK {
+		t.Errorf("second client: status %d, want 200", w.Code)
+	}
+}
+
+func TestNewRouterRejectsInvalidProxies(t *testing.T) {
+	if _, err := newRouter([]string{"not-an-address"}); err == nil {
+		t.Fatal("newRouter accepted an invalid trusted proxy")
+	}
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	intercept := grpcInterceptor([]string{"k1", "k2"}, 60, 60)
+	call := func(key string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+key))
+		_, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/iot.Home/ProcessCommand"},
+			func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+		return err
+	}
+	for i := 0; i < 60; i++ {
+		if err := call("k1"); err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+	}
+	if err := call("k1"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("call 61: %v, want ResourceExhausted", err)
+	}
+	if err := call("k2"); err != nil {
+		t.Errorf("k2: %v, want its own bucket", err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// DeviceRegistry maps every room-based target ("light", "fan", "door") to the
+// Firebase turn path of its device in each room. Rooms are free-form names
+// with explicit paths, e.g. {"light": {"garage": "light5/turn"}}, so adding
+// a room only takes a change to the devices file. Other fields of a device,
+// such as the light level, live next to the turn path.
+type DeviceRegistry map[string]map[string]string
+
+// registry holds the active DeviceRegistry. It is swapped atomically on
+// reload so readers never observe a partially loaded registry.
+var registry atomic.Pointer[DeviceRegistry]
+
+func init() {
+	r := defaultRegistry()
+	registry.Store(&r)
+}
+
+// defaultRegistry is used when no devices file is configured and mirrors
+// the original wiring of the house.
+func defaultRegistry() DeviceRegistry {
+	return DeviceRegistry{
+		"light": {
+			"living room": "light1/turn",
+			"bedroom":     "light2/turn",
+			"kitchen":     "light3/turn",
+			"toilet":      "light4/turn",
+			"wc":          "light4/turn",
+		},
+		"fan": {
+			"living room": "fan1/turn",
+			"bedroom":     "fan2/turn",
+			"kitchen":     "fan3/turn",
+			"toilet":      "fan4/turn",
+			"wc":          "fan4/turn",
+		},
+		"door": {
+			"front": "door/turn",
+			"back":  "door2/turn",
+		},
+		// Blinds take their position (0-100) instead of an on/off state.
+		"blind": {
+			"living room": "blind1/position",
+		},
+		// The house has a single sensor, shared by every room.
+		"sensor": {
+			"living room": "sensors/temperature",
+			"bedroom":     "sensors/temperature",
+			"kitchen":     "sensors/temperature",
+			"toilet":      "sensors/temperature",
+		},
+	}
+}
+
+func loadRegistry(file string) (DeviceRegistry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read devices file")
+	}
+	var raw DeviceRegistry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse devices file %s", file)
+	}
+	if err := raw.validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid devices file %s", file)
+	}
+
+	// Room names are matched case-insensitively, so store them the way
+	// normalizeLocation produces them.
+	r := make(DeviceRegistry, len(raw))
+	for target, rooms := range raw {
+		r[target] = make(map[string]string, len(rooms))
+		for room, path := range rooms {
+			name := strings.Join(strings.Fields(strings.ToLower(room)), " ")
+			if _, dup := r[target][name]; dup {
+				return nil, errors.Errorf("invalid devices file %s: %s room %q is defined twice", file, target, name)
+			}
+			r[target][name] = strings.TrimSpace(path)
+		}
+	}
+	return r, nil
+}
+
+func (r DeviceRegistry) validate() error {
+	for target, rooms := range r {
+		if len(rooms) == 0 {
+			return errors.Errorf("target %q has no rooms", target)
+		}
+		for room, path := range rooms {
+			if strings.TrimSpace(room) == "" {
+				return errors.Errorf("target %q has an empty room name", target)
+			}
+			if strings.EqualFold(strings.TrimSpace(room), "all") {
+				return errors.Errorf("target %q uses the reserved room name \"all\"", target)
+			}
+			if strings.TrimSpace(path) == "" {
+				return errors.Errorf("%s in %q has an empty path", target, room)
+			}
+			if err := validatePath(strings.TrimSpace(path)); err != nil {
+				return errors.Wrapf(err, "%s in %q", target, room)
+			}
+		}
+	}
+	return nil
+}
+
+// rooms returns the sorted names of every room with at least one device.
+func (r DeviceRegistry) rooms() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, rooms := range r {
+		for room := range rooms {
+			if !seen[room] {
+				seen[room] = true
+				names = append(names, room)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r DeviceRegistry) count() int {
+	n := 0
+	for _, rooms := range r {
+		n += len(rooms)
+	}
+	return n
+}
+
+// devicePaths returns the path of the given field for every room of target.
+func devicePaths(target, field string) map[string]string {
+	rooms := (*registry.Load())[target]
+	paths := make(map[string]string, len(rooms))
+	for room, turnPath := range rooms {
+		paths[room] = fieldPath(turnPath, field)
+	}
+	return paths
+}
+
+// resolveRoomPaths returns the paths of field addressed by location, which
+// is either a room of target or "all" once normalized. Rooms sharing a
+// device (such as "toilet" and "wc") only yield its path once. An unknown
+// room may be corrected to a close one, see correctLocation.
+func resolveRoomPaths(target, field, location string) ([]string, error) {
+	paths := devicePaths(target, field)
+	location = normalizeLocation(location)
+	if location != "all" {
+		path, ok := paths[location]
+		if !ok {
+			room, err := correctLocation(target, location, paths)
+			if err != nil {
+				return nil, err
+			}
+			path = paths[room]
+		}
+		return []string{path}, nil
+	}
+
+	seen := make(map[string]bool, len(paths))
+	all := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			all = append(all, path)
+		}
+	}
+	sort.Strings(all)
+	return all, nil
+}
+
+// expandLocations replaces "all" with every room of target, skipping rooms
+// that share a device with one already listed, and a zone with the rooms
+// of its members, so that every member gets its own result.
+func expandLocations(target string, locations []string) []string {
+	expanded := make([]string, 0, len(locations))
+	for _, location := range locations {
+		if rooms, ok := (*zones.Load()).rooms(target, normalizeLocation(location)); ok {
+			expanded = append(expanded, rooms...)
+			continue
+		}
+		if normalizeLocation(location) != "all" {
+			expanded = append(expanded, location)
+			continue
+		}
+		rooms := (*registry.Load())[target]
+		names := make([]string, 0, len(rooms))
+		for room := range rooms {
+			names = append(names, room)
+		}
+		sort.Strings(names)
+		seen := make(map[string]bool, len(rooms))
+		for _, room := range names {
+			if !seen[rooms[room]] {
+				seen[rooms[room]] = true
+				expanded = append(expanded, room)
+			}
+		}
+	}
+	return expanded
+}
+
+// validatePath rejects device paths that could address a node outside the
+// device tree: parent segments, absolute or empty segments, control
+// characters and the characters Firebase forbids in keys. Paths only come
+// from the registry today, but every write is checked so that a room name
+// or path derived from user input can never reach an arbitrary node.
+func validatePath(path string) error {
+	if path == "" {
+		return errors.New("empty device path")
+	}
+	if strings.HasPrefix(path, "/") {
+		return errors.Errorf("device path %q must not start with a slash", path)
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return errors.Errorf("device path %q has an invalid segment %q", path, segment)
+		}
+	}
+	for _, r := range path {
+		if unicode.IsControl(r) || strings.ContainsRune(".#$[]", r) {
+			return errors.Errorf("device path %q contains the invalid character %q", path, r)
+		}
+	}
+	return nil
+}
+
+// fieldPath derives the path of a sibling field from a device turn path,
+// e.g. "light1/turn" becomes "light1/level".
+func fieldPath(turnPath, field string) string {
+	if field == turnField {
+		return turnPath
+	}
+	if i := strings.LastIndexByte(turnPath, '/'); i >= 0 {
+		return turnPath[:i+1] + field
+	}
+	return turnPath + "/" + field
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValidatePath(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"light1/turn", true},
+		{"door/lock", true},
+		{"light/living room/turn", true},
+		{"", false},
+		{"/light1/turn", false},
+		{"../../../admin", false},
+		{"light1/../../users", false},
+		{"light1/./turn", false},
+		{"light1//turn", false},
+		{"light1/turn/", false},
+		{"light1/turn\x00", false},
+		{"light1\n/turn", false},
+		{"light1/turn.json", false},
+		{"light1/#turn", false},
+		{"$light/turn", false},
+		{"light[1]/turn", false},
+	}
+	for _, tt := range tests {
+		if err := validatePath(tt.path); (err == nil) != tt.ok {
+			t.Errorf("validatePath(%q) = %v, want ok %v", tt.path, err, tt.ok)
+		}
+	}
+}
+
+func TestSetValueRefusesInvalidPaths(t *testing.T) {
+	f := useFakeBackend(t)
+	for _, path := range []string{"../../../history", "/light1/turn", "light1/turn\x00"} {
+		if err := setValue(context.Background(), path, actionOn); err == nil {
+			t.Errorf("write to %q accepted", path)
+		}
+	}
+	if len(f.writes) != 0 {
+		t.Fatalf("writes %+v, want none", f.writes)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fileConfig is the configuration read from the files named in Config: the
+// device registry, the zones, the scenes, the action synonyms and the
+// prompt template. It can be reloaded while the service runs.
+type fileConfig struct {
+	registry   DeviceRegistry
+	zones      ZoneRegistry
+	scenes     SceneRegistry
+	synonyms   map[string]string
+	prompt     *template.Template
+	promptText string
+}
+
+var (
+	// reloadMu serializes reloads, so that each one is compared with the
+	// configuration it replaces.
+	reloadMu sync.Mutex
+	// activeFiles is the configuration installed last, guarded by reloadMu.
+	activeFiles fileConfig
+)
+
+// loadFileConfig reads and validates every configuration file of cfg; the
+// built-in defaults stand in for the files that are not set. Nothing is
+// installed, so a failure leaves the running configuration untouched.
+func loadFileConfig(cfg Config) (fileConfig, error) {
+	var (
+		fc  fileConfig
+		err error
+	)
+	fc.registry = defaultRegistry()
+	if cfg.DevicesFile != "" {
+		if fc.registry, err = loadRegistry(cfg.DevicesFile); err != nil {
+			return fileConfig{}, err
+		}
+	}
+	fc.zones = ZoneRegistry{}
+	if cfg.ZonesFile != "" {
+		if fc.zones, err = loadZones(cfg.ZonesFile, fc.registry); err != nil {
+			return fileConfig{}, err
+		}
+	}
+	fc.scenes = defaultScenes()
+	if cfg.ScenesFile != "" {
+		if fc.scenes, err = loadScenes(cfg.ScenesFile); err != nil {
+			return fileConfig{}, err
+		}
+	}
+	if fc.synonyms, err = loadActionSynonyms(cfg.ActionSynonymsFile); err != nil {
+		return fileConfig{}, err
+	}
+	if fc.prompt, fc.promptText, err = loadPromptTemplate(cfg.PromptTemplateFile); err != nil {
+		return fileConfig{}, err
+	}
+	return fc, nil
+}
+
+// reloadFiles loads the configuration files of cfg and, when they are all
+// valid, swaps them in. It returns a summary of what changed.
+func reloadFiles(cfg Config) ([]string, error) {
+	fc, err := loadFileConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloaded := activeFiles.registry != nil
+	changes := fc.diff(activeFiles)
+	registry.Store(&fc.registry)
+	zones.Store(&fc.zones)
+	scenes.Store(&fc.scenes)
+	actionSynonyms.Store(&fc.synonyms)
+	promptTemplate.Store(fc.prompt)
+	activeFiles = fc
+	attrs := []interface{}{"devices", fc.registry.count(), "zones", len(fc.zones), "scenes", len(fc.scenes), "synonyms", len(fc.synonyms)}
+	if !reloaded {
+		slog.Info("configuration files loaded", attrs...)
+		return changes, nil
+	}
+	slog.Info("configuration files reloaded", append(attrs, "changes", changes)...)
+	return changes, nil
+}
+
+// diff describes how fc differs from old, one line per changed item.
+func (fc fileConfig) diff(old fileConfig) []string {
+	var changes []string
+	for _, target := range unionKeys(fc.registry, old.registry) {
+		changes = append(changes, diffMap("devices of "+target, fc.registry[target], old.registry[target])...)
+	}
+	changes = append(changes, diffMap("zones", stringifyZones(fc.zones), stringifyZones(old.zones))...)
+	changes = append(changes, diffMap("scenes", stringifyScenes(fc.scenes), stringifyScenes(old.scenes))...)
+	changes = append(changes, diffMap("action synonyms", fc.synonyms, old.synonyms)...)
+	if fc.promptText != old.promptText {
+		changes = append(changes, "prompt template changed")
+	}
+	return changes
+}
+
+// diffMap summarizes the keys added to, removed from and changed in next
+// compared with prev.
+func diffMap(name string, next, prev map[string]string) []string {
+	var added, removed, changed []string
+	for key, value := range next {
+		if before, ok := prev[key]; !ok {
+			added = append(added, key)
+		} else if before != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range prev {
+		if _, ok := next[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	var lines []string
+	for _, d := range []struct {
+		verb string
+		keys []string
+	}{{"added", added}, {"removed", removed}, {"changed", changed}} {
+		if len(d.keys) > 0 {
+			sort.Strings(d.keys)
+			lines = append(lines, fmt.Sprintf("%s %s: %v", name, d.verb, d.keys))
+		}
+	}
+	return lines
+}
+
+func unionKeys(a, b DeviceRegistry) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var keys []string
+	for _, r := range []DeviceRegistry{a, b} {
+		for key := range r {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func stringifyZones(z ZoneRegistry) map[string]string {
+	m := make(map[string]string, len(z))
+	for name, members := range z {
+		m[name] = fmt.Sprint(members)
+	}
+	return m
+}
+
+// stringifyScenes renders the steps of every scene as JSON, so that steps
+// holding pointers such as a condition compare by value.
+func stringifyScenes(s SceneRegistry) map[string]string {
+	m := make(map[string]string, len(s))
+	for name, steps := range s {
+		data, _ := json.Marshal(steps)
+		m[name] = string(data)
+	}
+	return m
+}
+
+// watchReload reloads the configuration files whenever the process
+// receives SIGHUP. Files that fail to load leave the current configuration
+// in place.
+func watchReload(cfg Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := reloadFiles(cfg); err != nil {
+				slog.Error("failed to reload configuration files", "error", err)
+			}
+		}
+	}()
+}
+
+// handleReload reloads the configuration files and reports what changed,
+// or why the files were rejected.
+func handleReload(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		changes, err := reloadFiles(cfg)
+		if err != nil {
+			logFromContext(c.Request.Context()).Error("failed to reload configuration files", "error", err)
+			respond(c, http.StatusUnprocessableEntity, gin.H{responseError: "Configuration not reloaded: " + err.Error()})
+			return
+		}
+		if changes == nil {
+			changes = []string{}
+		}
+		respond(c, http.StatusOK, gin.H{"message": "Configuration reloaded", "changes": changes})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReloadScenes(t *testing.T) {
+	t.Cleanup(func() {
+		if _, err := reloadFiles(Config{}); err != nil {
+			t.Error(err)
+		}
+	})
+	file := filepath.Join(t.TempDir(), "scenes.json")
+	write := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := Config{ScenesFile: file}
+
+	write(`{"movie": [{"target": "light", "action": "off", "location": "living room"}]}`)
+	if _, err := reloadFiles(cfg); err != nil {
+		t.Fatal(err)
+	}
+	write(`{"movie": [{"target": "light", "action": "off", "location": "all"}], "wake up": [{"target": "blind", "action": "open", "location": "living room"}]}`)
+	changes, err := reloadFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"scenes added: [wake up]", "scenes changed: [movie]"}; !slices.Equal(changes, want) {
+		t.Errorf("changes %q, want %q", changes, want)
+	}
+	if got := scenes.Load().names(); !slices.Equal(got, []string{"movie", "wake up"}) {
+		t.Errorf("scenes %v after reload", got)
+	}
+
+	write(`{"broken": []}`)
+	if _, err := reloadFiles(cfg); err == nil {
+		t.Fatal("invalid scenes file reloaded")
+	}
+	if got := scenes.Load().names(); !slices.Equal(got, []string{"movie", "wake up"}) {
+		t.Errorf("scenes %v after a failed reload", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response is the envelope of every JSON reply, so that clients parse one
+// schema whatever the endpoint. Success follows the HTTP status; the
+// message and error of a handler body get their own fields and everything
+// else goes into Data.
+type Response struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Data    gin.H  `json:"data,omitempty"`
+}
+
+// newResponse wraps the status and body produced by a handler.
+func newResponse(status int, body gin.H) Response {
+	resp := Response{Success: status < http.StatusBadRequest}
+	for key, value := range body {
+		switch key {
+		case "message":
+			resp.Message = fmt.Sprint(value)
+		case responseError:
+			resp.Error = fmt.Sprint(value)
+		default:
+			if resp.Data == nil {
+				resp.Data = gin.H{}
+			}
+			resp.Data[key] = value
+		}
+	}
+	return resp
+}
+
+// respond writes status and body in the Response envelope, with the
+// message in the language the client asked for.
+func respond(c *gin.Context, status int, body gin.H) {
+	c.JSON(status, localizeResponse(newResponse(status, body), requestLanguage(c)))
+}
+
+// abortWithResponse is respond for middleware that stops the chain.
+func abortWithResponse(c *gin.Context, status int, body gin.H) {
+	c.AbortWithStatusJSON(status, localizeResponse(newResponse(status, body), requestLanguage(c)))
+}
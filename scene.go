@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const sceneTarget = "scene"
+
+// SceneRegistry maps a scene name, such as "good night", to the commands it
+// runs in order. Steps are plain commands, e.g.
+// {"good night": [{"target": "light", "action": "off", "location": "all"}]}.
+type SceneRegistry map[string][]AIResponse
+
+// scenes holds the active scenes, swapped in by reloadFiles.
+var scenes atomic.Pointer[SceneRegistry]
+
+func init() {
+	s := defaultScenes()
+	scenes.Store(&s)
+}
+
+// defaultScenes is used when no scenes file is configured.
+func defaultScenes() SceneRegistry {
+	return SceneRegistry{
+		"good night": {
+			{Target: "light", Action: "off", Location: "all"},
+			{Target: "fan", Action: "off", Location: "all"},
+			{Target: "door", Action: "close", Location: "front"},
+		},
+	}
+}
+
+func loadScenes(file string) (SceneRegistry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read scenes file")
+	}
+	var raw SceneRegistry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse scenes file %s", file)
+	}
+
+	s := make(SceneRegistry, len(raw))
+	for name, steps := range raw {
+		key := sceneName(name)
+		if key == "" {
+			return nil, errors.Errorf("invalid scenes file %s: empty scene name", file)
+		}
+		if _, dup := s[key]; dup {
+			return nil, errors.Errorf("invalid scenes file %s: scene %q is defined twice", file, key)
+		}
+		if len(steps) == 0 {
+			return nil, errors.Errorf("invalid scenes file %s: scene %q has no steps", file, key)
+		}
+		for i, step := range steps {
+			if step.Target == sceneTarget {
+				return nil, errors.Errorf("invalid scenes file %s: step %d of scene %q runs another scene", file, i+1, key)
+			}
+			if err := validateAIResponse(step); err != nil {
+				return nil, errors.Wrapf(err, "invalid scenes file %s: step %d of scene %q", file, i+1, key)
+			}
+		}
+		s[key] = steps
+	}
+	return s, nil
+}
+
+// sceneName normalizes a scene name the way room names are.
+func sceneName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// names returns the sorted scene names.
+func (s SceneRegistry) names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sceneStepResult is the outcome of one step of a scene.
+type sceneStepResult struct {
+	Step   AIResponse `json:"step"`
+	OK     bool       `json:"ok"`
+	Status int        `json:"status"`
+	Result gin.H      `json:"result"`
+}
+
+// handleScene runs every step of the scene named by the command's content
+// (or location), carrying on past failures. Each step goes through
+// runCommand, so it gets the same validation and owner checks as a
+// command of its own.
+func handleScene(ctx context.Context, response AIResponse) (int, gin.H) {
+	name := sceneName(response.Content)
+	if name == "" {
+		name = sceneName(response.Location)
+	}
+	steps, ok := (*scenes.Load())[name]
+	if !ok {
+		return errorResponse(errors.Wrapf(ErrUnknownScene, "no scene named %q", name))
+	}
+
+	results := make([]sceneStepResult, 0, len(steps))
+	failed, firstFailure := 0, 0
+	for _, step := range steps {
+		status, body := runCommand(ctx, step)
+		ok := status < http.StatusBadRequest
+		if !ok {
+			failed++
+			if firstFailure == 0 {
+				firstFailure = status
+			}
+		}
+		results = append(results, sceneStepResult{Step: step, OK: ok, Status: status, Result: body})
+	}
+	logFromContext(ctx).Info("scene executed", "scene", name, "steps", len(steps), "failed", failed)
+
+	switch {
+	case failed == 0:
+		return http.StatusOK, gin.H{"message": fmt.Sprintf("Scene %s done", name), "scene": name, "steps": results}
+	case failed < len(results):
+		return http.StatusMultiStatus, gin.H{
+			responseError: fmt.Sprintf("%d of %d steps failed", failed, len(results)),
+			"scene":       name,
+			"steps":       results,
+		}
+	default:
+		return firstFailure, gin.H{responseError: fmt.Sprintf("Scene %s failed", name), "scene": name, "steps": results}
+	}
+}
+
+// handleSceneRequest runs the scene named in the URL, e.g.
+// POST /api/scene/good%20night.
+func handleSceneRequest(c *gin.Context) {
+	executeCommand(c, "", AIResponse{Target: sceneTarget, Action: "run", Content: c.Param("name")}, "")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const maxDelay = 24 * time.Hour
+
+// scheduledTask is a command waiting for its delay to elapse.
+type scheduledTask struct {
+	ID      string     `json:"id"`
+	Command AIResponse `json:"command"`
+	RunAt   time.Time  `json:"runAt"`
+	timer   *time.Timer
+}
+
+// taskScheduler keeps delayed commands in memory until they fire. Pending
+// tasks do not survive a restart: shutdown drops them and logs each one.
+type taskScheduler struct {
+	mu     sync.Mutex
+	tasks  map[string]*scheduledTask
+	wg     sync.WaitGroup
+	closed bool
+}
+
+var scheduler = &taskScheduler{tasks: make(map[string]*scheduledTask)}
+
+func (s *taskScheduler) schedule(ctx context.Context, command AIResponse, delay time.Duration) (scheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return scheduledTask{}, errors.New("scheduler is shutting down")
+	}
+
+	command.Delay = nil
+	task := &scheduledTask{ID: uuid.NewString(), Command: command, RunAt: time.Now().Add(delay)}
+	s.wg.Add(1)
+	// The task outlives the request that scheduled it, but keeps its
+	// request-scoped values such as the logger.
+	ctx = context.WithoutCancel(ctx)
+	task.timer = time.AfterFunc(delay, func() { s.run(ctx, task) })
+	s.tasks[task.ID] = task
+	return *task, nil
+}
+
+func (s *taskScheduler) run(ctx context.Context, task *scheduledTask) {
+	defer s.wg.Done()
+	s.mu.Lock()
+	delete(s.tasks, task.ID)
+	s.mu.Unlock()
+
+	// The writes belong to this run, not to the request that scheduled it.
+	rec := &commandRecorder{}
+	ctx = context.WithValue(withTaskID(ctx, task.ID), recorderKey{}, rec)
+	status, body := runCommand(ctx, task.Command)
+	rec.mu.Lock()
+	writes := rec.writes
+	rec.mu.Unlock()
+	saveHistory(ctx, instructionFromContext(ctx), task.Command, writes, status)
+	logFromContext(ctx).Info("scheduled command executed", "task_id", task.ID, "status", status, "result", body)
+	webhooks.notify(ctx, webhookPayload{
+		RequestID: requestIDFromContext(ctx),
+		TaskID:    task.ID,
+		Command:   task.Command,
+		Status:    status,
+		Result:    newResponse(status, body),
+	})
+}
+
+type taskIDKey struct{}
+
+func withTaskID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, taskIDKey{}, id)
+}
+
+func taskIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(taskIDKey{}).(string)
+	return id
+}
+
+// list returns the pending tasks ordered by the time they will run.
+func (s *taskScheduler) list() []scheduledTask {
+	s.mu.Lock()
+	tasks := make([]scheduledTask, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, *task)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].RunAt.Before(tasks[j].RunAt) })
+	return tasks
+}
+
+// shutdown cancels every pending task and waits for the ones already
+// running to finish their device writes.
+func (s *taskScheduler) shutdown() {
+	s.mu.Lock()
+	s.closed = true
+	for id, task := range s.tasks {
+		if task.timer.Stop() {
+			s.wg.Done()
+			logFromContext(context.Background()).Warn("dropping scheduled command", "task_id", id, "run_at", task.RunAt)
+		}
+		delete(s.tasks, id)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// parseDelay converts the delay reported by the model, in seconds.
+func parseDelay(v interface{}) (time.Duration, error) {
+	seconds, err := parseNumber("delay", v)
+	if err != nil {
+		return 0, err
+	}
+	delay := time.Duration(seconds * float64(time.Second))
+	if delay < 0 || delay > maxDelay {
+		return 0, errors.Errorf("Delay must be between 0 and %s", maxDelay)
+	}
+	return delay, nil
+}
+
+func handleSchedules(c *gin.Context) {
+	respond(c, http.StatusOK, gin.H{"tasks": scheduler.list()})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestScheduledCommandRecordsHistory(t *testing.T) {
+	f := useFakeBackend(t)
+	prev := scheduler
+	scheduler = &taskScheduler{tasks: make(map[string]*scheduledTask)}
+	t.Cleanup(func() { scheduler = prev })
+
+	command := AIResponse{Target: "light", Action: "on", Location: "kitchen", Delay: 0.01}
+	status, body := recordCommand(context.Background(), "turn on the kitchen light shortly", command, false)
+	if status != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %v", status, body)
+	}
+	scheduler.wg.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries := f.pushes[historyPath]
+	if len(entries) != 2 {
+		t.Fatalf("%d history entries, want one when scheduled and one when run", len(entries))
+	}
+	entry := entries[1].(historyEntry)
+	if entry.Status != http.StatusOK || entry.Result != "success" {
+		t.Errorf("run recorded status %d, result %q", entry.Status, entry.Result)
+	}
+	if entry.Instruction != "turn on the kitchen light shortly" {
+		t.Errorf("run recorded instruction %q", entry.Instruction)
+	}
+	if entry.TaskID == "" || len(entry.Writes) == 0 {
+		t.Errorf("run recorded task %q and writes %v", entry.TaskID, entry.Writes)
+	}
+}
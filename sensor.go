@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const sensorTarget = "sensor"
+
+// sensorMetrics are the readings a sensor reports. The registry holds the
+// path of one reading per room, e.g. "sensors/temperature"; the others are
+// its siblings, like the fields of any other device.
+var sensorMetrics = []string{"temperature", "humidity"}
+
+// ErrReadOnly is returned when a write targets a sensor reading.
+var ErrReadOnly = errors.New("Sensors are read-only")
+
+// handleSensor reads a sensor metric, named in the content, in one room or
+// in every room. A command without a location reads the house sensor when
+// every room shares one.
+func handleSensor(ctx context.Context, response AIResponse) (int, gin.H) {
+	metric := strings.ToLower(strings.TrimSpace(response.Content))
+	if metric == "" {
+		metric = sensorMetrics[0]
+	}
+	if !slices.Contains(sensorMetrics, metric) {
+		return errorResponse(errors.Wrapf(ErrInvalidAction, "unknown sensor reading %q, expected one of %s", metric, strings.Join(sensorMetrics, ", ")))
+	}
+
+	location := normalizeLocation(response.Location)
+	if location == "" {
+		paths, _ := resolveRoomPaths(sensorTarget, metric, "all")
+		if len(paths) != 1 {
+			return errorResponse(errors.Wrap(ErrInvalidLocation, "Which room? Please name the room"))
+		}
+		value, err := readState(ctx, paths[0])
+		if err != nil {
+			return errorStatus(err), gin.H{responseError: "Failed to read " + metric}
+		}
+		return http.StatusOK, gin.H{"message": fmt.Sprintf("The %s is %v", metric, value), "metric": metric, "value": value}
+	}
+
+	if location == "all" {
+		readings := make(map[string]interface{})
+		for room, path := range devicePaths(sensorTarget, metric) {
+			value, err := readState(ctx, path)
+			if err != nil {
+				return errorStatus(err), gin.H{responseError: "Failed to read " + metric}
+			}
+			readings[room] = value
+		}
+		return http.StatusOK, gin.H{"message": fmt.Sprintf("The %s in every room", metric), "metric": metric, "readings": readings}
+	}
+
+	paths, err := resolveRoomPaths(sensorTarget, metric, location)
+	if err != nil {
+		return errorResponse(err)
+	}
+	value, err := readState(ctx, paths[0])
+	if err != nil {
+		return errorStatus(err), gin.H{responseError: "Failed to read " + metric}
+	}
+	return http.StatusOK, gin.H{"message": fmt.Sprintf("The %s in %s is %v", metric, location, value), "metric": metric, "value": value}
+}
+
+// sensorPath reports whether path belongs to a sensor, so that no command
+// can overwrite a reading.
+func sensorPath(path string) bool {
+	for _, sensor := range (*registry.Load())[sensorTarget] {
+		for _, metric := range sensorMetrics {
+			if path == fieldPath(sensor, metric) {
+				return true
+			}
+		}
+	}
+	return false
+}
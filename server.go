@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// minTLSVersion is the oldest TLS version the HTTPS server accepts. TLS 1.2
+// is still needed by some embedded clients; older versions are refused.
+const minTLSVersion = tls.VersionTLS12
+
+// serve runs handler until SIGINT or SIGTERM is received, then stops
+// accepting connections and waits up to cfg.ShutdownTimeout for in-flight
+// requests (and their device writes) to finish.
+func serve(cfg Config, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: cfg.ListenPort, Handler: handler}
+	if cfg.tlsEnabled() {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to load TLS certificate")
+		}
+		// With a TLS config and no TLSNextProto, net/http negotiates
+		// HTTP/2 over ALPN on its own.
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: minTLSVersion}
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("server listening", "addr", cfg.ListenPort, "tls", cfg.tlsEnabled())
+		if cfg.tlsEnabled() {
+			errCh <- server.ListenAndServeTLS("", "")
+			return
+		}
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return errors.Wrap(err, "server stopped unexpectedly")
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutdown started", "timeout", cfg.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return errors.Wrap(err, "failed to shut down gracefully")
+	}
+	slog.Info("shutdown completed")
+	return nil
+}
+
+// newRouter returns an engine answering unknown routes and methods in
+// JSON. Gin trusts X-Forwarded-For from any peer by default, which would
+// let a client choose its own rate limit bucket; only trustedProxies may
+// set it here.
+func newRouter(trustedProxies []string) (*gin.Engine, error) {
+	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, errors.Wrap(err, "invalid TRUSTED_PROXIES")
+	}
+	r.HandleMethodNotAllowed = true
+	r.NoRoute(handleNotFound)
+	r.NoMethod(handleMethodNotAllowed)
+	return r, nil
+}
+
+// handleNotFound answers unknown routes in JSON, like every other error.
+func handleNotFound(c *gin.Context) {
+	respond(c, http.StatusNotFound, gin.H{responseError: "not found", "path": c.Request.URL.Path})
+}
+
+// handleMethodNotAllowed answers known routes called with the wrong method.
+// Gin has already set the Allow header.
+func handleMethodNotAllowed(c *gin.Context) {
+	respond(c, http.StatusMethodNotAllowed, gin.H{responseError: "method not allowed", "method": c.Request.Method, "path": c.Request.URL.Path})
+}
+
+// limitBody refuses request bodies larger than limit bytes with 413
+// Request Entity Too Large. The body is read here, before any handler
+// binds it, so an oversized payload is never buffered beyond the limit.
+func limitBody(limit int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tooLarge := gin.H{responseError: "request body too large", "limit": limit}
+		if c.Request.ContentLength > int64(limit) {
+			abortWithResponse(c, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, int64(limit)))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				abortWithResponse(c, http.StatusRequestEntityTooLarge, tooLarge)
+				return
+			}
+			abortWithResponse(c, http.StatusBadRequest, gin.H{responseError: "failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newFallbackRouter has one route and the not-found and method handlers of
+// the real router.
+func newFallbackRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r, err := newRouter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.POST("/api", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder) Response {
+	t.Helper()
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Fatalf("Content-Type %q, want JSON", ct)
+	}
+	var resp Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("body %q is not a JSON response: %v", w.Body, err)
+	}
+	return resp
+}
+
+func TestUnknownRoute(t *testing.T) {
+	w := httptest.NewRecorder()
+	newFallbackRouter(t).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/nothing-here", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want 404", w.Code)
+	}
+	resp := decodeResponse(t, w)
+	if resp.Success || resp.Error != "not found" || resp.Data["path"] != "/api/nothing-here" {
+		t.Errorf("response %+v, want a not found error for the path", resp)
+	}
+}
+
+func TestWrongMethod(t *testing.T) {
+	w := httptest.NewRecorder()
+	newFallbackRouter(t).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status %d, want 405", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != http.MethodPost {
+		t.Errorf("Allow %q, want POST", allow)
+	}
+	resp := decodeResponse(t, w)
+	if resp.Error != "method not allowed" || resp.Data["method"] != http.MethodGet {
+		t.Errorf("response %+v, want a method not allowed error", resp)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	const limit = 64
+	tests := []struct {
+		name   string
+		body   string
+		chunk  bool
+		status int
+	}{
+		{name: "small", body: `{"instruction":"turn on the light"}`, status: http.StatusOK},
+		{name: "at the limit", body: strings.Repeat("a", limit), status: http.StatusOK},
+		{name: "oversized", body: strings.Repeat("a", limit+1), status: http.StatusRequestEntityTooLarge},
+		// Without a Content-Length, the limit applies while the body is read.
+		{name: "oversized without length", body: strings.Repeat("a", 4*limit), chunk: true, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			var received string
+			r.POST("/api", limitBody(limit), func(c *gin.Context) {
+				body, _ := io.ReadAll(c.Request.Body)
+				received = string(body)
+				c.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(tt.body))
+			if tt.chunk {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusOK && received != tt.body {
+				t.Errorf("handler read %q, want %q", received, tt.body)
+			}
+			if tt.status == http.StatusRequestEntityTooLarge {
+				if resp := decodeResponse(t, w); resp.Error != "request body too large" || resp.Data["limit"] != float64(limit) {
+					t.Errorf("response %+v, want the limit", resp)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// modelOutput is the model answer as received, before it is parsed into a
+// command.
+type modelOutput struct {
+	Text      string     `json:"text"`
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	// Fallback is set when the model failed and the keyword parser
+	// classified the instruction instead.
+	Fallback bool `json:"fallback,omitempty"`
+	// Cached is set when the command came from the response cache, which
+	// keeps no model output.
+	Cached bool `json:"cached,omitempty"`
+	// fresh asks for a new answer from the model, bypassing the cache.
+	fresh bool
+}
+
+type modelOutputKey struct{}
+
+// withModelOutput returns ctx in which the classification records the raw
+// model answer into out. The response cache is bypassed when out is fresh.
+func withModelOutput(ctx context.Context, out *modelOutput) context.Context {
+	return context.WithValue(ctx, modelOutputKey{}, out)
+}
+
+func modelOutputFromContext(ctx context.Context) *modelOutput {
+	out, _ := ctx.Value(modelOutputKey{}).(*modelOutput)
+	return out
+}
+
+// captureModelOutput stores the raw model answer when ctx asks for it.
+func captureModelOutput(ctx context.Context, text string, calls []toolCall) {
+	if out := modelOutputFromContext(ctx); out != nil {
+		out.Text, out.ToolCalls = text, calls
+	}
+}
+
+// handleSimulate classifies an instruction and returns the command together
+// with the raw model answer, without running it. Unlike a dry run nothing
+// is resolved to device paths and the command is shown as the model wrote
+// it, before actions are canonicalized, which makes it a tool for tuning
+// the prompt.
+func handleSimulate(cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var inst Instruction
+		if err := c.ShouldBindJSON(&inst); err != nil {
+			status, body := bindingErrorResponse(err)
+			respond(c, status, body)
+			return
+		}
+		instruction, err := sanitizeInstruction(inst.Instruction, cfg.MaxInstructionLength)
+		if err != nil {
+			respond(c, http.StatusBadRequest, gin.H{responseError: err.Error()})
+			return
+		}
+		if inst.Model != "" && !cfg.modelAllowed(inst.Model) {
+			respond(c, http.StatusBadRequest, gin.H{responseError: fmt.Sprintf("Model %q is not allowed", inst.Model)})
+			return
+		}
+
+		out := &modelOutput{fresh: true}
+		response, err := getAIResponse(withModelOutput(c.Request.Context(), out), instruction, inst.Model)
+		if err != nil {
+			status, body := aiErrorResponse(cfg, err)
+			body["raw"] = out
+			respond(c, status, body)
+			return
+		}
+		respond(c, http.StatusOK, gin.H{
+			"instruction": instruction,
+			"model":       cfg.modelOrDefault(inst.Model),
+			"command":     response,
+			"raw":         out,
+		})
+	}
+}
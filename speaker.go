@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	speakerTarget     = "speaker"
+	speakerVolumePath = "speaker/volume"
+	speakerMutePath   = "speaker/mute"
+)
+
+// handleSpeaker sets the volume of the speaker or mutes it. Setting a
+// volume also unmutes, like turning the knob of a real speaker. The body
+// reports the resulting volume and mute state; after mute and unmute the
+// volume is read back, and left out if it cannot be.
+func handleSpeaker(ctx context.Context, response AIResponse) (int, gin.H) {
+	switch response.Action {
+	case "set", "volume", "set volume":
+		volume, err := parseVolume(response.Volume)
+		if err != nil {
+			return http.StatusBadRequest, gin.H{responseError: err.Error()}
+		}
+		if err := setValues(ctx, map[string]interface{}{speakerVolumePath: volume, speakerMutePath: false}); err != nil {
+			return writeFailure(err, "Failed to set speaker volume")
+		}
+		return http.StatusOK, gin.H{"message": fmt.Sprintf("Speaker volume set to %d", volume), "volume": volume, "muted": false}
+	case "mute", "unmute":
+		muted := response.Action == "mute"
+		if err := setValue(ctx, speakerMutePath, muted); err != nil {
+			return writeFailure(err, "Failed to "+response.Action+" speaker")
+		}
+		body := gin.H{"message": fmt.Sprintf("Speaker %sd", response.Action), "muted": muted}
+		if current, err := readState(ctx, speakerVolumePath); err == nil && current != nil {
+			body["volume"] = current
+		}
+		return http.StatusOK, body
+	default:
+		return errorResponse(unsupportedAction(response))
+	}
+}
+
+// parseVolume converts the volume reported by the model and rejects values
+// outside 0-100.
+func parseVolume(v interface{}) (int, error) {
+	f, err := parseNumber("volume", v)
+	if err != nil {
+		return 0, err
+	}
+	volume := int(math.Round(f))
+	if volume < 0 || volume > 100 {
+		return 0, errors.New("Volume must be between 0 and 100")
+	}
+	return volume, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// statusTargets are the targets included in the house status snapshot, and
+// statusReadLimit bounds how many of their paths are read at once.
+var statusTargets = []string{"light", "fan", "door", "blind"}
+
+const statusReadLimit = 8
+
+// stateTargets lists the readable paths of every target, keyed by location.
+func stateTargets(target string) (map[string]string, bool) {
+	switch target {
+	case "light", "fan", "door", "blind":
+		return devicePaths(target, turnField), true
+	default:
+		return nil, false
+	}
+}
+
+// handleState returns the stored state of a device, or of every room when
+// the location is "all".
+func handleState(c *gin.Context) {
+	ctx := c.Request.Context()
+	target, location := c.Param("target"), normalizeLocation(c.Param("location"))
+
+	paths, ok := stateTargets(target)
+	if !ok {
+		respond(c, http.StatusNotFound, gin.H{responseError: "Unknown target"})
+		return
+	}
+
+	if location == "all" {
+		states, err := readStates(ctx, paths)
+		if err != nil {
+			respond(c, errorStatus(err), gin.H{responseError: err.Error()})
+			return
+		}
+		respond(c, http.StatusOK, gin.H{"target": target, "location": location, "state": states})
+		return
+	}
+
+	path, ok := paths[location]
+	if !ok {
+		respond(c, http.StatusNotFound, gin.H{responseError: "Unknown location"})
+		return
+	}
+	state, err := readState(ctx, path)
+	if err != nil {
+		respond(c, errorStatus(err), gin.H{responseError: err.Error()})
+		return
+	}
+	respond(c, http.StatusOK, gin.H{"target": target, "location": location, "state": state})
+}
+
+func readState(ctx context.Context, path string) (interface{}, error) {
+	if err := validatePath(path); err != nil {
+		return nil, err
+	}
+	var state interface{}
+	if err := store.Get(ctx, path, &state); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+	return state, nil
+}
+
+// readStates reads every path of paths, keyed by location. Paths are
+// grouped by the top-level node they live under and the groups are read
+// concurrently, so that a target wired as light1/turn, light2/turn, ... costs
+// one round trip of latency rather than one per device.
+func readStates(ctx context.Context, paths map[string]string) (map[string]interface{}, error) {
+	states := make(map[string]interface{}, len(paths))
+	var mu sync.Mutex
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(statusReadLimit)
+	for node, group := range groupByNode(paths) {
+		g.Go(func() error {
+			values, err := readGroup(ctx, node, group)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for location, v := range values {
+				states[location] = v
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// groupByNode splits paths by their top-level node, such as "light" for
+// light/living/turn and light/kitchen/turn, or "light1" for light1/turn.
+func groupByNode(paths map[string]string) map[string]map[string]string {
+	groups := make(map[string]map[string]string)
+	for location, path := range paths {
+		node, _, _ := strings.Cut(path, "/")
+		if groups[node] == nil {
+			groups[node] = make(map[string]string)
+		}
+		groups[node][location] = path
+	}
+	return groups
+}
+
+// readGroup reads paths, which all live under node. Distinct paths sharing
+// node are read with a single call for the whole node; a lone path, or paths
+// under the command history, are read on their own. Locations wired to the
+// same path share one read.
+func readGroup(ctx context.Context, node string, paths map[string]string) (map[string]interface{}, error) {
+	distinct := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if err := validatePath(path); err != nil {
+			return nil, err
+		}
+		distinct[path] = true
+	}
+	if len(distinct) > 1 && node != historyPath {
+		return readNode(ctx, node, paths)
+	}
+	read := make(map[string]interface{}, len(distinct))
+	states := make(map[string]interface{}, len(paths))
+	for location, path := range paths {
+		state, ok := read[path]
+		if !ok {
+			var err error
+			if state, err = readState(ctx, path); err != nil {
+				return nil, err
+			}
+			read[path] = state
+		}
+		states[location] = state
+	}
+	return states, nil
+}
+
+// readNode reads the top-level node in one call and picks the state of
+// every path of paths out of it.
+func readNode(ctx context.Context, node string, paths map[string]string) (map[string]interface{}, error) {
+	var tree interface{}
+	if err := store.Get(ctx, node, &tree); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", node)
+	}
+	states := make(map[string]interface{}, len(paths))
+	for location, path := range paths {
+		rest, _ := strings.CutPrefix(path, node)
+		states[location] = lookupPath(tree, strings.TrimPrefix(rest, "/"))
+	}
+	return states, nil
+}
+
+// lookupPath returns the value at path inside tree, or nil when it is
+// missing. The database returns children with numeric keys as arrays.
+func lookupPath(tree interface{}, path string) interface{} {
+	if path == "" {
+		return tree
+	}
+	for _, segment := range strings.Split(path, "/") {
+		switch node := tree.(type) {
+		case map[string]interface{}:
+			tree = node[segment]
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			tree = node[i]
+		default:
+			return nil
+		}
+	}
+	return tree
+}
+
+// deviceState is the stored value of one device path in a status snapshot.
+// Error is set instead of State when the path could not be read.
+type deviceState struct {
+	Path  string      `json:"path"`
+	State interface{} `json:"state"`
+	Error string      `json:"error,omitempty"`
+}
+
+// houseStatus reads every known device concurrently and returns a snapshot
+// grouped by target and location. The devices are read in the groups of
+// readGroup; a failure of one read is reported for each device of its group.
+func houseStatus(ctx context.Context) gin.H {
+	devices := make(map[string]map[string]*deviceState, len(statusTargets))
+	var g errgroup.Group
+	g.SetLimit(statusReadLimit)
+	for _, target := range statusTargets {
+		paths, _ := stateTargets(target)
+		devices[target] = make(map[string]*deviceState, len(paths))
+		for node, group := range groupByNode(paths) {
+			states := make(map[string]*deviceState, len(group))
+			for location, path := range group {
+				states[location] = &deviceState{Path: path}
+				devices[target][location] = states[location]
+			}
+			g.Go(func() error {
+				values, err := readGroup(ctx, node, group)
+				for location, state := range states {
+					if err != nil {
+						state.Error = err.Error()
+						continue
+					}
+					state.State = values[location]
+				}
+				return nil
+			})
+		}
+	}
+	_ = g.Wait()
+
+	return gin.H{"message": "House status", "timestamp": time.Now().UTC(), "devices": devices}
+}
+
+func handleHouseStatus(c *gin.Context) {
+	respond(c, http.StatusOK, houseStatus(c.Request.Context()))
+}
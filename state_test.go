@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupByNode(t *testing.T) {
+	paths := map[string]string{
+		"living":  "light/living/turn",
+		"kitchen": "light/kitchen/turn",
+		"hall":    "light1/turn",
+		"front":   "door/turn",
+	}
+	want := map[string]map[string]string{
+		"light":  {"living": "light/living/turn", "kitchen": "light/kitchen/turn"},
+		"light1": {"hall": "light1/turn"},
+		"door":   {"front": "door/turn"},
+	}
+	if got := groupByNode(paths); !reflect.DeepEqual(got, want) {
+		t.Errorf("groupByNode() = %v, want %v", got, want)
+	}
+}
+
+func TestReadStates(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths map[string]string
+		reads []string
+		want  map[string]interface{}
+	}{
+		{
+			name:  "batched",
+			paths: map[string]string{"living": "light/living/turn", "kitchen": "light/kitchen/turn", "hall": "light/hall/turn"},
+			reads: []string{"light"},
+			want:  map[string]interface{}{"living": "on", "kitchen": "off", "hall": nil},
+		},
+		{
+			name:  "one by one",
+			paths: map[string]string{"living": "light1/turn", "bedroom": "light2/turn"},
+			want:  map[string]interface{}{"living": "on", "bedroom": "off"},
+		},
+		{
+			name:  "shared path",
+			paths: map[string]string{"toilet": "light4/turn", "wc": "light4/turn"},
+			reads: []string{"light4/turn"},
+			want:  map[string]interface{}{"toilet": "off", "wc": "off"},
+		},
+		{
+			name:  "history",
+			paths: map[string]string{"a": "history/secret", "b": "history/other"},
+			want:  map[string]interface{}{"a": nil, "b": nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeBackend(t)
+			f.state["light"] = map[string]interface{}{
+				"living":  map[string]interface{}{"turn": "on"},
+				"kitchen": map[string]interface{}{"turn": "off"},
+			}
+			f.state["history"] = map[string]interface{}{"secret": "instruction"}
+			f.state["light1/turn"] = "on"
+			f.state["light2/turn"] = "off"
+			f.state["light4/turn"] = "off"
+			got, err := readStates(context.Background(), tt.paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readStates() = %v, want %v", got, tt.want)
+			}
+			if tt.reads != nil && !reflect.DeepEqual(f.reads, tt.reads) {
+				t.Errorf("reads %v, want %v", f.reads, tt.reads)
+			}
+			sort.Strings(f.reads)
+			if tt.reads == nil && len(f.reads) != len(tt.paths) {
+				t.Errorf("reads %v, want one per path", f.reads)
+			}
+		})
+	}
+}
+
+// slowStore delays every read and records how many overlapped.
+type slowStore struct {
+	*fakeBackend
+	mu              sync.Mutex
+	inFlight, maxIn int
+}
+
+func (s *slowStore) Get(ctx context.Context, path string, v interface{}) error {
+	s.mu.Lock()
+	s.inFlight++
+	s.maxIn = max(s.maxIn, s.inFlight)
+	s.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+	return s.fakeBackend.Get(ctx, path, v)
+}
+
+func TestReadStatesDefaultRegistry(t *testing.T) {
+	prev := registry.Load()
+	r := defaultRegistry()
+	registry.Store(&r)
+	t.Cleanup(func() { registry.Store(prev) })
+	f := useFakeBackend(t)
+	slow := &slowStore{fakeBackend: f}
+	store = slow
+	for _, path := range []string{"light1/turn", "light2/turn", "light3/turn", "light4/turn"} {
+		f.state[path] = "on"
+	}
+
+	paths, _ := stateTargets("light")
+	states, err := readStates(context.Background(), paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != len(paths) || states["wc"] != "on" {
+		t.Errorf("readStates() = %v", states)
+	}
+	sort.Strings(f.reads)
+	if want := []string{"light1/turn", "light2/turn", "light3/turn", "light4/turn"}; !reflect.DeepEqual(f.reads, want) {
+		t.Errorf("reads %v, want %v", f.reads, want)
+	}
+	if slow.maxIn < 2 {
+		t.Errorf("at most %d reads in flight, want the devices read concurrently", slow.maxIn)
+	}
+}
+
+func BenchmarkGroupByNode(b *testing.B) {
+	shared := map[string]string{"living": "light/living/turn", "bedroom": "light/bedroom/turn", "kitchen": "light/kitchen/turn", "bathroom": "light/bathroom/turn"}
+	numbered := map[string]string{"living": "light1/turn", "bedroom": "light2/turn", "kitchen": "light3/turn", "bathroom": "light4/turn"}
+	for name, paths := range map[string]map[string]string{"shared": shared, "numbered": numbered} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				groupByNode(paths)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// firebaseScopes are the OAuth scopes needed to use the Realtime Database
+// REST API with the service account.
+var firebaseScopes = []string{
+	"https://www.googleapis.com/auth/firebase.database",
+	"https://www.googleapis.com/auth/userinfo.email",
+}
+
+// stateEvent is a change of the value stored at Path.
+type stateEvent struct {
+	Path string      `json:"path"`
+	Data interface{} `json:"data"`
+}
+
+// newFirebaseHTTPClient returns an HTTP client authorised as the service
+// account. It has no timeout because it is used for long-lived streams.
+func newFirebaseHTTPClient(ctx context.Context, cfg Config) (*http.Client, error) {
+	if cfg.emulated() {
+		return &http.Client{}, nil
+	}
+	data, err := os.ReadFile(cfg.CredFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read credential file")
+	}
+	creds, err := google.CredentialsFromJSON(ctx, data, firebaseScopes...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load Firebase credentials")
+	}
+	return oauth2.NewClient(context.Background(), creds.TokenSource), nil
+}
+
+// restURL returns the REST endpoint of node, on the emulator when one is
+// configured.
+func (cfg Config) restURL(node string) string {
+	if !cfg.emulated() {
+		return strings.TrimSuffix(cfg.DatabaseURL, "/") + "/" + node + ".json"
+	}
+	return "http://" + cfg.EmulatorHost + "/" + node + ".json?ns=" + url.QueryEscape(cfg.emulatorNamespace())
+}
+
+// emulatorNamespace is the name the emulator knows the database by. The
+// emulator serves every database on one host and tells them apart by the
+// ns parameter, the first label of the production host name.
+func (cfg Config) emulatorNamespace() string {
+	ns := strings.TrimPrefix(strings.TrimPrefix(cfg.DatabaseURL, "https://"), "http://")
+	ns, _, _ = strings.Cut(ns, ".")
+	return ns
+}
+
+// listenFirebase streams the changes below node into events until ctx is
+// done. The Admin SDK for Go has no listener API, so this uses the
+// Realtime Database REST streaming protocol (server-sent events) and
+// reconnects with backoff whenever the stream drops.
+func listenFirebase(ctx context.Context, hc *http.Client, url, node string, events chan<- stateEvent) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := streamFirebase(ctx, hc, url, node, events)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		logFromContext(ctx).Warn("Firebase stream interrupted", "node", node, "error", err, "retry_in", backoff.String())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func streamFirebase(ctx context.Context, hc *http.Client, url, node string, events chan<- stateEvent) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to build stream request")
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to open stream")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("stream returned status %d", resp.StatusCode)
+	}
+
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if event == "cancel" || event == "auth_revoked" {
+				return errors.Errorf("stream closed by server: %s", event)
+			}
+			if event != "put" && event != "patch" {
+				continue
+			}
+			var payload stateEvent
+			if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &payload); err != nil {
+				return errors.Wrap(err, "failed to decode stream event")
+			}
+			payload.Path = strings.TrimSuffix(node+payload.Path, "/")
+			select {
+			case events <- payload:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "failed to read stream")
+	}
+	return errors.New("stream ended")
+}
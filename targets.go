@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TargetHandler executes the commands addressed to one target and returns
+// the HTTP status and body describing the outcome. Handlers return a body
+// rather than a single message because room-based targets report the
+// result of every room.
+type TargetHandler interface {
+	Handle(ctx context.Context, response AIResponse) (int, gin.H)
+}
+
+// TargetHandlerFunc adapts a function to the TargetHandler interface.
+type TargetHandlerFunc func(ctx context.Context, response AIResponse) (int, gin.H)
+
+func (f TargetHandlerFunc) Handle(ctx context.Context, response AIResponse) (int, gin.H) {
+	return f(ctx, response)
+}
+
+// targetHandlers holds the handler of every target, and targetActions the
+// actions each target supports; anything else is rejected before any
+// device is touched. Both are filled by registerTarget.
+var (
+	targetHandlers = map[string]TargetHandler{}
+	targetActions  = map[string][]string{}
+)
+
+// registerTarget makes target available to commands. Adding a target only
+// takes a handler and a call to registerTarget in init below.
+func registerTarget(target string, actions []string, handler TargetHandler) {
+	if _, dup := targetHandlers[target]; dup {
+		panic(fmt.Sprintf("target %q registered twice", target))
+	}
+	targetHandlers[target] = handler
+	targetActions[target] = actions
+}
+
+func init() {
+	registerTarget("light", []string{"on", "off", "open", "close", "toggle", "dim", "brightness", "set brightness", "increase", "decrease", "color", "set color"}, TargetHandlerFunc(handleLight))
+	registerTarget("fan", []string{"on", "off", "open", "close", "toggle"}, TargetHandlerFunc(handleFan))
+	registerTarget("door", []string{"open", "close", "on", "off", "lock", "unlock"}, TargetHandlerFunc(handleDoor))
+	registerTarget("ac", []string{"on", "off", "set"}, TargetHandlerFunc(processAC))
+	registerTarget("status", []string{"get"}, TargetHandlerFunc(handleStatus))
+	registerTarget(sensorTarget, []string{"get", "read"}, TargetHandlerFunc(handleSensor))
+	registerTarget(speakerTarget, []string{"set", "volume", "set volume", "mute", "unmute"}, TargetHandlerFunc(handleSpeaker))
+	registerTarget(sceneTarget, []string{"run", "on"}, TargetHandlerFunc(handleScene))
+	for target, node := range mediaNodes {
+		registerTarget(target, []string{"play", "stop", "pause", "off"}, mediaHandler(node))
+	}
+	for _, target := range []string{"blind", "curtain"} {
+		registerTarget(target, []string{"open", "close", "set"}, TargetHandlerFunc(processBlind))
+	}
+}
+
+// switchValue maps the on/off style actions to the value written to a
+// device turn path.
+func switchValue(action string) (string, bool) {
+	value, ok := map[string]string{"on": actionOn, "off": actionOff, "open": actionOn, "close": actionOff}[action]
+	return value, ok
+}
+
+func handleLight(ctx context.Context, response AIResponse) (int, gin.H) {
+	if colorActions[response.Action] || response.Color != "" {
+		return setLightColor(ctx, response)
+	}
+	if levelActions[response.Action] {
+		level, err := parseLevel(response.Level)
+		if err != nil {
+			return http.StatusBadRequest, gin.H{responseError: err.Error()}
+		}
+		locations := response.targetLocations()
+		results := updateRoomDevices(ctx, "light", levelField, locations, level)
+		return roomResultsResponse(results, fmt.Sprintf("Light level set to %d in %s", level, strings.Join(locations, ", ")))
+	}
+	if sign, ok := levelSteps[response.Action]; ok {
+		return adjustLight(ctx, response, sign)
+	}
+	return handleSwitch(ctx, "light", response)
+}
+
+// adjustLight makes the light brighter or dimmer than it is now. The level
+// of the command, when given, is the size of the step.
+func adjustLight(ctx context.Context, response AIResponse, sign int) (int, gin.H) {
+	step := levelStep
+	if response.Level != nil {
+		level, err := parseLevel(response.Level)
+		if err != nil {
+			return http.StatusBadRequest, gin.H{responseError: err.Error()}
+		}
+		step = level
+	}
+	locations := response.targetLocations()
+	results := modifyRoomDevices(ctx, "light", levelField, locations, func(current interface{}) interface{} {
+		return adjustLevel(current, sign*step)
+	})
+	return roomResultsResponse(results, fmt.Sprintf("Light level %sd by %d in %s", response.Action, step, strings.Join(locations, ", ")))
+}
+
+func handleFan(ctx context.Context, response AIResponse) (int, gin.H) {
+	return handleSwitch(ctx, "fan", response)
+}
+
+// handleSwitch turns a room-based target on or off, or toggles it.
+func handleSwitch(ctx context.Context, target string, response AIResponse) (int, gin.H) {
+	locations := response.targetLocations()
+	if response.Action == "toggle" {
+		results := toggleRoomDevices(ctx, target, locations)
+		return roomResultsResponse(results, fmt.Sprintf("Toggled %s in %s", target, strings.Join(locations, ", ")))
+	}
+	value, ok := switchValue(response.Action)
+	if !ok {
+		return errorResponse(unsupportedAction(response))
+	}
+	results := updateRoomDevices(ctx, target, turnField, locations, value)
+	name := strings.ToUpper(target[:1]) + target[1:]
+	return roomResultsResponse(results, fmt.Sprintf("%s %s in %s", name, response.Action, strings.Join(locations, ", ")))
+}
+
+// lockValue maps the lock actions of a door to the value of its lock
+// path: "1" engages the bolt.
+func lockValue(action string) (string, bool) {
+	value, ok := map[string]string{"lock": actionOn, "unlock": actionOff}[action]
+	return value, ok
+}
+
+// handleDoor opens or closes the door relay, or engages and releases the
+// bolt of a smart lock, which is a separate lock path next to it.
+func handleDoor(ctx context.Context, response AIResponse) (int, gin.H) {
+	if value, ok := lockValue(response.Action); ok {
+		return processDoor(ctx, response, lockField, value)
+	}
+	value, ok := switchValue(response.Action)
+	if !ok {
+		return errorResponse(unsupportedAction(response))
+	}
+	return processDoor(ctx, response, turnField, value)
+}
+
+func handleStatus(ctx context.Context, _ AIResponse) (int, gin.H) {
+	return http.StatusOK, houseStatus(ctx)
+}
+
+func mediaHandler(node string) TargetHandler {
+	return TargetHandlerFunc(func(ctx context.Context, response AIResponse) (int, gin.H) {
+		return processMedia(ctx, node, response)
+	})
+}
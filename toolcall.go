@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Output formats of the model, selected with AI_OUTPUT_FORMAT. With
+// "auto" a tool call is used when the response has one and the text is
+// parsed otherwise.
+const (
+	outputAuto      = "auto"
+	outputText      = "text"
+	outputToolCalls = "tool_calls"
+)
+
+// commandTool is the name of the function the model is asked to call.
+const commandTool = "control_device"
+
+// toolCall is a function call in a chat response. OpenAI sends the
+// arguments as a JSON-encoded string, Ollama as a JSON object; both are
+// accepted.
+type toolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// parseModelOutput turns a model response, given as its text and tool
+// calls, into a validated AIResponse according to format.
+func parseModelOutput(format, text string, calls []toolCall) (AIResponse, error) {
+	switch {
+	case format == outputText:
+		return parseAIOutput(text)
+	case len(calls) > 0:
+		return parseToolCall(calls[0])
+	case format == outputToolCalls:
+		return AIResponse{}, &quotedTextError{msg: "AI response contains no tool call, only the text", text: text}
+	default:
+		return parseAIOutput(text)
+	}
+}
+
+// parseToolCall reads the command from the arguments of call. A model that
+// names the function after the target instead of passing it as an argument
+// is understood too.
+func parseToolCall(call toolCall) (AIResponse, error) {
+	args := bytes.TrimSpace(call.Function.Arguments)
+	if len(args) > 0 && args[0] == '"' {
+		var encoded string
+		if err := json.Unmarshal(args, &encoded); err != nil {
+			return AIResponse{}, errors.Wrap(err, "failed to decode tool call arguments")
+		}
+		args = []byte(encoded)
+	}
+
+	var response AIResponse
+	if err := json.Unmarshal(args, &response); err != nil {
+		return AIResponse{}, errors.Wrapf(err, "failed to parse arguments of tool call %q", call.Function.Name)
+	}
+	if _, ok := targetActions[call.Function.Name]; ok && response.Target == "" {
+		response.Target = call.Function.Name
+	}
+	if err := validateAIResponse(response); err != nil {
+		return response, err
+	}
+	return response, nil
+}
+
+// commandTools returns the tool definitions sent to chat models that are
+// expected to answer with a tool call.
+func commandTools() []map[string]interface{} {
+	targets := make([]string, 0, len(targetActions))
+	for target := range targetActions {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	number := map[string]interface{}{"type": "number"}
+	return []map[string]interface{}{{
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":        commandTool,
+			"description": "Control or query a device of the house.",
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"target":      map[string]interface{}{"type": "string", "enum": targets},
+					"action":      map[string]interface{}{"type": "string"},
+					"content":     map[string]interface{}{"type": "string"},
+					"location":    map[string]interface{}{"type": "string"},
+					"locations":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+					"level":       number,
+					"delay":       number,
+					"temperature": number,
+					"position":    number,
+					"volume":      number,
+					"color":       map[string]interface{}{"type": "string"},
+					"condition": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"target":        map[string]interface{}{"type": "string"},
+							"location":      map[string]interface{}{"type": "string"},
+							"expectedState": map[string]interface{}{"type": "string"},
+						},
+						"required": []string{"target", "expectedState"},
+					},
+				},
+				"required": []string{"target", "action"},
+			},
+		},
+	}}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveFixture answers every request with the file testdata/name.
+func serveFixture(t *testing.T, name string) string {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestModelOutputFormats(t *testing.T) {
+	tests := []struct {
+		fixture string
+		format  string
+		ok      bool
+	}{
+		{"ollama_text.json", outputAuto, true},
+		{"ollama_text.json", outputText, true},
+		{"ollama_text.json", outputToolCalls, false},
+		{"ollama_tool_call.json", outputAuto, true},
+		{"ollama_tool_call.json", outputToolCalls, true},
+		{"ollama_tool_call.json", outputText, false},
+		{"openai_text.json", outputAuto, true},
+		{"openai_text.json", outputToolCalls, false},
+		{"openai_tool_call.json", outputAuto, true},
+		{"openai_tool_call.json", outputToolCalls, true},
+		{"openai_tool_call.json", outputText, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture+"/"+tt.format, func(t *testing.T) {
+			cfg := Config{AIServiceURL: serveFixture(t, tt.fixture), AIModel: "phi3", AIMaxAttempts: 1, AITimeout: time.Second, AIOutputFormat: tt.format}
+			var p AIProvider = ollamaProvider{cfg: cfg}
+			if strings.HasPrefix(tt.fixture, "openai") {
+				p = openAIProvider{cfg: cfg}
+			}
+			response, err := p.Classify(context.Background(), "turn on the bedroom light", "")
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("Classify() = %+v, want an error", response)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Target != "light" || response.Action != "on" || response.Location != "bedroom" {
+				t.Errorf("Classify() = %+v, want the bedroom light on", response)
+			}
+		})
+	}
+}
+
+func TestParseToolCall(t *testing.T) {
+	tests := []struct {
+		name      string
+		function  string
+		arguments string
+		target    string
+		ok        bool
+	}{
+		{name: "object arguments", function: commandTool, arguments: `{"target":"fan","action":"off","location":"kitchen"}`, target: "fan", ok: true},
+		{name: "string arguments", function: commandTool, arguments: `"{\"target\":\"fan\",\"action\":\"off\",\"location\":\"kitchen\"}"`, target: "fan", ok: true},
+		{name: "function named after target", function: "fan", arguments: `{"action":"off","location":"kitchen"}`, target: "fan", ok: true},
+		{name: "malformed arguments", function: commandTool, arguments: `{"target":`},
+		{name: "unknown target", function: commandTool, arguments: `{"target":"oven","action":"on"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var call toolCall
+			call.Function.Name = tt.function
+			call.Function.Arguments = json.RawMessage(tt.arguments)
+			response, err := parseToolCall(call)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseToolCall() error = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && response.Target != tt.target {
+				t.Errorf("target %q, want %q", response.Target, tt.target)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	// Report fields under their JSON names, the ones clients send.
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// fieldError is a request field that failed to bind, and why.
+type fieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// bindingErrorResponse reports a ShouldBindJSON failure. A body that is
+// not JSON at all gets the generic message; otherwise the fields that were
+// missing, invalid or of the wrong type are listed.
+func bindingErrorResponse(err error) (int, gin.H) {
+	var fields []fieldError
+	var validationErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &validationErrs):
+		for _, fe := range validationErrs {
+			fields = append(fields, fieldError{Field: fe.Field(), Reason: validationReason(fe)})
+		}
+	case errors.As(err, &typeErr):
+		fields = append(fields, fieldError{Field: typeErr.Field, Reason: fmt.Sprintf("must be a %s, not a %s", jsonTypeName(typeErr.Type), typeErr.Value)})
+	default:
+		return http.StatusBadRequest, gin.H{responseError: "Invalid request payload"}
+	}
+	return http.StatusBadRequest, gin.H{responseError: "Invalid request payload", "fields": fields}
+}
+
+// jsonTypeName names t the way JSON does.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	case reflect.Interface:
+		return "value"
+	default:
+		return "number"
+	}
+}
+
+func validationReason(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "url":
+		return "must be a URL"
+	case "max":
+		return fmt.Sprintf("must be at most %s long", fe.Param())
+	default:
+		return fmt.Sprintf("failed the %q rule", fe.Tag())
+	}
+}
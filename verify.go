@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/pkg/errors"
+)
+
+// ErrWriteNotVerified is returned when a device path read back after a
+// write does not hold the value written.
+var ErrWriteNotVerified = errors.New("Device write could not be verified")
+
+// stateReader is implemented by backends whose writes can be read back.
+type stateReader interface {
+	Get(ctx context.Context, path string, v interface{}) error
+}
+
+// verifyTargets are the targets whose writes are read back and compared
+// with the intended value, set from VERIFY_WRITES in main. It costs a read
+// per write, so it is meant for safety-critical targets such as the door.
+var verifyTargets = map[string]bool{}
+
+type verifyKey struct{}
+
+// withWriteVerification returns ctx with verification of the writes made
+// for target turned on when target is one of verifyTargets.
+func withWriteVerification(ctx context.Context, target string) context.Context {
+	if !verifyTargets[target] {
+		return ctx
+	}
+	return context.WithValue(ctx, verifyKey{}, true)
+}
+
+func verifyingWrites(ctx context.Context) bool {
+	verify, _ := ctx.Value(verifyKey{}).(bool)
+	return verify
+}
+
+// verifyWrite reads path back through the backend when ctx asks for
+// verification and fails unless it holds value. Values are compared by
+// their JSON encoding, so the number 30 written matches the 30.0 read.
+func verifyWrite(ctx context.Context, path string, value interface{}) error {
+	if !verifyingWrites(ctx) {
+		return nil
+	}
+	reader, ok := backend.(stateReader)
+	if !ok {
+		return nil
+	}
+	var stored interface{}
+	if err := reader.Get(ctx, path, &stored); err != nil {
+		return errors.Wrapf(err, "failed to read back %s", path)
+	}
+	want, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode value written to %s", path)
+	}
+	got, err := json.Marshal(stored)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode value read from %s", path)
+	}
+	if !bytes.Equal(want, got) {
+		logFromContext(ctx).Error("device write not verified", "path", path, "value", value, "stored", stored)
+		return errors.Wrapf(ErrWriteNotVerified, "%s holds %s instead of %s", path, got, want)
+	}
+	logFromContext(ctx).Debug("device write verified", "path", path)
+	return nil
+}
+
+// installWriteVerification sets verifyTargets from the configured list.
+// Backends that cannot be read back only get a warning, since their
+// writes are then never verified.
+func installWriteVerification(targets []string) {
+	verifyTargets = make(map[string]bool, len(targets))
+	for _, target := range targets {
+		verifyTargets[target] = true
+	}
+	if _, ok := backend.(stateReader); !ok && len(targets) > 0 {
+		slog.Warn("the device backend cannot read back writes, VERIFY_WRITES has no effect", "backend", backendName)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// callbackHeader gives the URL notified when the command of a request
+	// completes, for requests whose body has no callbackUrl field.
+	callbackHeader = "X-Callback-URL"
+	// signatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+	// webhook body keyed with WEBHOOK_SECRET.
+	signatureHeader = "X-Webhook-Signature"
+
+	webhookEvent   = "command.completed"
+	webhookBackoff = time.Second
+)
+
+// webhookPayload is the body POSTed when a command completes. Result is
+// the same envelope the API replied, or would have replied, with.
+type webhookPayload struct {
+	Event       string     `json:"event"`
+	RequestID   string     `json:"request_id,omitempty"`
+	TaskID      string     `json:"task_id,omitempty"`
+	Instruction string     `json:"instruction,omitempty"`
+	Command     AIResponse `json:"command"`
+	Status      int        `json:"status"`
+	Result      Response   `json:"result"`
+	Timestamp   int64      `json:"timestamp"`
+}
+
+// webhookNotifier delivers completion callbacks in the background, retrying
+// failed deliveries with exponential backoff. A per-request callback URL
+// wins over the global defaultURL; without either nothing is sent.
+// Callbacks may only go to the host of defaultURL or to allowedHosts, so
+// that a caller cannot make the service POST to an address of its choice.
+type webhookNotifier struct {
+	defaultURL   string
+	allowedHosts map[string]bool
+	secret       string
+	attempts     int
+	client       *http.Client
+	wg           sync.WaitGroup
+}
+
+// webhooks is the active notifier, set up in main. It accepts no callback
+// until then.
+var webhooks = &webhookNotifier{attempts: 1, client: newWebhookClient(10*time.Second, false)}
+
+func newWebhookNotifier(cfg Config) *webhookNotifier {
+	n := &webhookNotifier{
+		defaultURL:   cfg.WebhookURL,
+		allowedHosts: make(map[string]bool),
+		secret:       cfg.WebhookSecret,
+		attempts:     cfg.WebhookMaxAttempts,
+		client:       newWebhookClient(cfg.WebhookTimeout, cfg.WebhookAllowPrivate),
+	}
+	for _, host := range cfg.WebhookAllowedHosts {
+		n.allowedHosts[strings.ToLower(host)] = true
+	}
+	if u, err := url.Parse(cfg.WebhookURL); err == nil && u.Host != "" {
+		n.allowedHosts[strings.ToLower(u.Hostname())] = true
+	}
+	return n
+}
+
+// newWebhookClient returns the client webhooks are sent with. Unless
+// allowPrivate is set it refuses to connect to loopback, link-local,
+// private and other non-public addresses, checked on the address actually
+// dialled so that a DNS name cannot point it at the local network. Redirects
+// are not followed, since they could lead anywhere.
+func newWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return errors.Errorf("webhook address %s is not a public address", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is a globally routable unicast address.
+func publicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+type callbackKey struct{}
+
+// withCallback returns ctx carrying the callback URL of a request. It
+// survives into scheduled commands, which are notified when they run.
+func withCallback(ctx context.Context, callback string) context.Context {
+	if callback == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, callbackKey{}, callback)
+}
+
+// parseWebhookURL parses an absolute http(s) URL.
+func parseWebhookURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Errorf("Invalid callback URL %q", raw)
+	}
+	return u, nil
+}
+
+// checkCallbackURL rejects callback URLs that are not absolute http(s) URLs
+// to a host the active notifier allows.
+func checkCallbackURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := parseWebhookURL(raw)
+	if err != nil {
+		return err
+	}
+	if !webhooks.allowedHosts[strings.ToLower(u.Hostname())] {
+		return errors.Errorf("Callback host %q is not allowed; add it to WEBHOOK_ALLOWED_HOSTS", u.Hostname())
+	}
+	return nil
+}
+
+// notify sends payload to the callback of ctx, or to the global webhook, in
+// the background. Commands still pending, answered 202 Accepted, are
+// notified later by the scheduler instead.
+func (n *webhookNotifier) notify(ctx context.Context, payload webhookPayload) {
+	if payload.Status == http.StatusAccepted {
+		return
+	}
+	target, _ := ctx.Value(callbackKey{}).(string)
+	if target == "" {
+		target = n.defaultURL
+	}
+	if target == "" {
+		return
+	}
+	payload.Event = webhookEvent
+	payload.Timestamp = time.Now().UnixMilli()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logFromContext(ctx).Error("failed to encode webhook payload", "error", err)
+		return
+	}
+
+	logger := logFromContext(ctx).With("callback_url", target)
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		backoff := webhookBackoff
+		for attempt := 1; ; attempt++ {
+			err := n.deliver(target, body)
+			if err == nil {
+				webhookDeliveries.WithLabelValues("delivered").Inc()
+				logger.Info("webhook delivered", "attempt", attempt)
+				return
+			}
+			if attempt >= n.attempts {
+				webhookDeliveries.WithLabelValues("failed").Inc()
+				logger.Error("webhook delivery failed", "attempts", attempt, "error", err)
+				return
+			}
+			logger.Warn("webhook delivery failed, retrying", "attempt", attempt, "backoff", backoff.String(), "error", err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}()
+}
+
+func (n *webhookNotifier) deliver(target string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to build webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, "sha256="+signPayload(n.secret, body))
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send webhook")
+	}
+	defer drainAndClose(resp.Body)
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return errors.Errorf("webhook receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+// signPayload returns the hex HMAC-SHA256 of body keyed with secret.
+// Receivers recompute it over the raw body to check the sender.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// wait blocks until the deliveries in progress are done or have given up.
+func (n *webhookNotifier) wait() {
+	n.wg.Wait()
+}
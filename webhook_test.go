@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useWebhooks(t *testing.T, n *webhookNotifier) {
+	t.Helper()
+	prev := webhooks
+	webhooks = n
+	t.Cleanup(func() { webhooks = prev })
+}
+
+func TestCheckCallbackURL(t *testing.T) {
+	useWebhooks(t, newWebhookNotifier(Config{
+		WebhookURL:          "https://hooks.example.com/iot",
+		WebhookAllowedHosts: []string{"Receiver.example.net"},
+		WebhookSecret:       "s3cret",
+		WebhookMaxAttempts:  1,
+		WebhookTimeout:      time.Second,
+	}))
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"", true},
+		{"https://hooks.example.com/other", true},
+		{"https://receiver.example.net:8443/cb", true},
+		{"http://RECEIVER.example.net/cb", true},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://127.0.0.1:8080/", false},
+		{"http://localhost/", false},
+		{"https://hooks.example.com.evil.test/", false},
+		{"ftp://hooks.example.com/", false},
+		{"hooks.example.com/iot", false},
+	}
+	for _, tt := range tests {
+		if err := checkCallbackURL(tt.url); (err == nil) != tt.ok {
+			t.Errorf("checkCallbackURL(%q) = %v, want ok %v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckCallbackURLWithoutWebhooks(t *testing.T) {
+	useWebhooks(t, newWebhookNotifier(Config{WebhookMaxAttempts: 1, WebhookTimeout: time.Second}))
+	if err := checkCallbackURL("https://hooks.example.com/iot"); err == nil {
+		t.Fatal("callback accepted with no allowed hosts")
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.10", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestWebhookRefusesPrivateAddress(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits++ }))
+	defer srv.Close()
+
+	n := newWebhookNotifier(Config{WebhookURL: srv.URL, WebhookSecret: "s3cret", WebhookMaxAttempts: 1, WebhookTimeout: time.Second})
+	if err := n.deliver(srv.URL, []byte("{}")); err == nil || !strings.Contains(err.Error(), "not a public address") {
+		t.Fatalf("deliver to %s = %v, want a refused address", srv.URL, err)
+	}
+	if hits != 0 {
+		t.Fatalf("receiver was called %d times", hits)
+	}
+}
+
+func TestWebhookSignedDelivery(t *testing.T) {
+	got := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- r
+		bodies <- string(body)
+	}))
+	defer srv.Close()
+
+	n := newWebhookNotifier(Config{
+		WebhookURL:          srv.URL,
+		WebhookSecret:       "s3cret",
+		WebhookMaxAttempts:  1,
+		WebhookTimeout:      time.Second,
+		WebhookAllowPrivate: true,
+	})
+	n.notify(context.Background(), webhookPayload{Status: http.StatusOK})
+	n.wait()
+
+	r, body := <-got, <-bodies
+	if want := "sha256=" + signPayload("s3cret", []byte(body)); r.Header.Get(signatureHeader) != want {
+		t.Fatalf("signature %q, want %q", r.Header.Get(signatureHeader), want)
+	}
+}
+
+func TestWebhookDoesNotFollowRedirects(t *testing.T) {
+	var redirected bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { redirected = true }))
+	defer target.Close()
+	srv := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer srv.Close()
+
+	n := newWebhookNotifier(Config{WebhookURL: srv.URL, WebhookSecret: "s3cret", WebhookMaxAttempts: 1, WebhookTimeout: time.Second, WebhookAllowPrivate: true})
+	if err := n.deliver(srv.URL, []byte("{}")); err == nil {
+		t.Fatal("redirect counted as a delivery")
+	}
+	if redirected {
+		t.Fatal("redirect was followed")
+	}
+}
+
+func TestConfigRequiresWebhookSecret(t *testing.T) {
+	t.Setenv("FIREBASE_DATABASE_EMULATOR_HOST", "localhost:9000")
+	for env, value := range map[string]string{"WEBHOOK_URL": "https://hooks.example.com/iot", "WEBHOOK_ALLOWED_HOSTS": "hooks.example.com"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			cfg, err := loadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "WEBHOOK_SECRET") {
+				t.Fatalf("validate() = %v, want a missing WEBHOOK_SECRET error", err)
+			}
+			cfg.WebhookSecret = "s3cret"
+			if err := cfg.validate(); err != nil {
+				t.Fatalf("validate() with a secret = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+const (
+	wsSendBuffer   = 16
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+)
+
+var errTooManyStreams = errors.New("Too many state stream connections")
+
+// stateHub fans device changes out to WebSocket clients. The Firebase
+// listeners only run while at least one client is connected.
+type stateHub struct {
+	cfg Config
+
+	mu      sync.Mutex
+	clients map[chan stateEvent]struct{}
+	cancel  context.CancelFunc
+}
+
+func newStateHub(cfg Config) *stateHub {
+	return &stateHub{cfg: cfg, clients: make(map[chan stateEvent]struct{})}
+}
+
+// subscribe registers a client, starting the listeners for the first one.
+func (h *stateHub) subscribe() (chan stateEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients) >= h.cfg.MaxStreamClients {
+		return nil, errTooManyStreams
+	}
+	if len(h.clients) == 0 {
+		if err := h.start(); err != nil {
+			return nil, err
+		}
+	}
+	ch := make(chan stateEvent, wsSendBuffer)
+	h.clients[ch] = struct{}{}
+	return ch, nil
+}
+
+// unsubscribe removes a client, stopping the listeners after the last one.
+func (h *stateHub) unsubscribe(ch chan stateEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, ch)
+	if len(h.clients) == 0 && h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+	}
+}
+
+// start must be called with h.mu held.
+func (h *stateHub) start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	hc, err := newFirebaseHTTPClient(ctx, h.cfg)
+	if err != nil {
+		cancel()
+		return err
+	}
+	h.cancel = cancel
+
+	events := make(chan stateEvent)
+	for _, node := range watchedNodes() {
+		go listenFirebase(ctx, hc, h.cfg.restURL(node), node, events)
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				h.broadcast(event)
+			}
+		}
+	}()
+	return nil
+}
+
+// broadcast delivers event to every client without blocking on slow ones;
+// a client whose buffer is full misses the event.
+func (h *stateHub) broadcast(event stateEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- event:
+		default:
+			slog.Warn("dropping state event for slow client", "path", event.Path)
+		}
+	}
+}
+
+// watchedNodes returns the top-level Firebase nodes holding device state.
+func watchedNodes() []string {
+	seen := make(map[string]bool)
+	add := func(path string) {
+		node, _, _ := strings.Cut(path, "/")
+		seen[node] = true
+	}
+	for _, target := range statusTargets {
+		paths, _ := stateTargets(target)
+		for _, path := range paths {
+			add(path)
+		}
+	}
+	add(acTurnPath)
+
+	nodes := make([]string, 0, len(seen))
+	for node := range seen {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// handleStateStream upgrades the request to a WebSocket and pushes every
+// device change as JSON until the client disconnects.
+func handleStateStream(hub *stateHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		events, err := hub.subscribe()
+		if errors.Is(err, errTooManyStreams) {
+			respond(c, http.StatusServiceUnavailable, gin.H{responseError: err.Error()})
+			return
+		}
+		if err != nil {
+			respond(c, http.StatusInternalServerError, gin.H{responseError: err.Error()})
+			return
+		}
+		defer hub.unsubscribe(events)
+
+		conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Reading is only needed to notice when the client goes away.
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case event := <-events:
+				conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if err := conn.WriteJSON(event); err != nil {
+					return
+				}
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
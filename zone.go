@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// ZoneRegistry maps a zone name, such as "upstairs", to the device turn
+// paths it groups, e.g. {"upstairs": ["light2/turn", "light4/turn",
+// "fan2/turn"]}. A zone is used like a room: a command for a target in a
+// zone applies to the members of that zone that belong to the target.
+type ZoneRegistry map[string][]string
+
+// zones holds the active zones, set up in main.
+var zones atomic.Pointer[ZoneRegistry]
+
+func init() {
+	zones.Store(&ZoneRegistry{})
+}
+
+// loadZones reads a zones file. Zone names must not shadow a room, and
+// every member must be the turn path of a device of reg.
+func loadZones(file string, reg DeviceRegistry) (ZoneRegistry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read zones file")
+	}
+	var raw ZoneRegistry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse zones file %s", file)
+	}
+
+	rooms := make(map[string]bool)
+	for _, room := range reg.rooms() {
+		rooms[room] = true
+	}
+	registered := make(map[string]bool)
+	for _, devices := range reg {
+		for _, path := range devices {
+			registered[path] = true
+		}
+	}
+
+	z := make(ZoneRegistry, len(raw))
+	for name, members := range raw {
+		key := normalizeLocation(name)
+		switch {
+		case key == "":
+			return nil, errors.Errorf("invalid zones file %s: empty zone name", file)
+		case key == "all" || rooms[key]:
+			return nil, errors.Errorf("invalid zones file %s: zone %q has the name of a room", file, key)
+		case len(members) == 0:
+			return nil, errors.Errorf("invalid zones file %s: zone %q has no members", file, key)
+		}
+		if _, dup := z[key]; dup {
+			return nil, errors.Errorf("invalid zones file %s: zone %q is defined twice", file, key)
+		}
+		for _, member := range members {
+			if !registered[strings.TrimSpace(member)] {
+				return nil, errors.Errorf("invalid zones file %s: member %q of zone %q is not a registered device", file, member, key)
+			}
+			z[key] = append(z[key], strings.TrimSpace(member))
+		}
+	}
+	return z, nil
+}
+
+// rooms returns the rooms of target whose devices are members of zone, one
+// room per device, or false when zone is not a zone of target.
+func (z ZoneRegistry) rooms(target, zone string) ([]string, bool) {
+	members, ok := z[zone]
+	if !ok {
+		return nil, false
+	}
+	devices := (*registry.Load())[target]
+	names := make([]string, 0, len(devices))
+	for room := range devices {
+		names = append(names, room)
+	}
+	sort.Strings(names)
+
+	var rooms []string
+	for _, member := range members {
+		for _, room := range names {
+			if devices[room] == member {
+				rooms = append(rooms, room)
+				break
+			}
+		}
+	}
+	return rooms, len(rooms) > 0
+}
+
+// names returns the sorted zone names.
+func (z ZoneRegistry) names() []string {
+	names := make([]string, 0, len(z))
+	for name := range z {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}